@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/urfave/cli"
+
+	"rmica/defs"
+	"rmica/logger"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var CheckpointCommand = cli.Command{
+	Name:  "checkpoint",
+	Usage: "checkpoint a running container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container to be
+checkpointed.`,
+	Description: `The checkpoint command saves the client of a running container to an image
+directory: its configuration, the firmware it runs (with a digest), the CPU it
+is assigned to and, if micad can export it, the state of the client. The
+client is stopped and removed afterwards unless --leave-running is given.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "image-path",
+			Value: "",
+			Usage: "path for saving the checkpoint image",
+		},
+		cli.BoolFlag{
+			Name:  "leave-running",
+			Usage: "leave the client running after checkpointing",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		imagePath := context.String("image-path")
+		if imagePath == "" {
+			return errors.New("--image-path is required")
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		return container.Checkpoint(&libcontainer.CriuOpts{
+			ImagesDirectory: imagePath,
+			LeaveRunning:    context.Bool("leave-running"),
+		})
+	},
+}
+
+var RestoreCommand = cli.Command{
+	Name:  "restore",
+	Usage: "restore a container from a previous checkpoint",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container that you
+are restoring. The name you provide for the container instance must be unique
+on your host.`,
+	Description: `The restore command creates a container for a bundle and recreates its client
+from the image directory written by "rmica checkpoint": on the same CPU, with
+the same firmware, booted from the exported state if there is one and micad
+can load it, afresh otherwise. The bundle is a directory with a specification
+file named "` + defs.SpecConfig + `" and a root filesystem.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "image-path",
+			Value: "",
+			Usage: "path to the checkpoint image",
+		},
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: "",
+			Usage: `path to the root of the bundle directory, defaults to the current directory`,
+		},
+		cli.StringFlag{
+			Name:  "console-socket",
+			Value: "",
+			Usage: "path to an AF_UNIX socket which will receive a file descriptor referencing the master end of the console's pseudoterminal",
+		},
+		cli.StringFlag{
+			Name:  "pid-file",
+			Value: "",
+			Usage: "specify the file to write the process id to",
+		},
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "detach from the container's process",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		imagePath := context.String("image-path")
+		if imagePath == "" {
+			return errors.New("--image-path is required")
+		}
+		status, err := pseudo_container.StartContainer(context, defs.CT_ACT_RESTORE, &libcontainer.CriuOpts{
+			ImagesDirectory: imagePath,
+		})
+		logger.Debugf("status = %d", status)
+		if err == nil {
+			os.Exit(status)
+		}
+		return fmt.Errorf("`rmica restore` failed: %w", err)
+	},
+}
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/communication/micadtest"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+// rmica runs `rmica args` against the container root and micad of srv and
+// returns what it printed.
+func rmica(t *testing.T, root string, srv *micadtest.Server, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	app := cli.NewApp()
+	app.Writer = &out
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "root"},
+		cli.StringFlag{Name: "mica-socket"},
+		cli.StringFlag{Name: "mica-dir"},
+		cli.DurationFlag{Name: "mica-timeout"},
+		cli.StringFlag{Name: "mica-protocol"},
+		cli.BoolFlag{Name: "debug"},
+		cli.StringFlag{Name: "log"},
+		cli.StringFlag{Name: "log-format", Value: "text"},
+	}
+	app.Commands = []cli.Command{
+		StateCommand,
+		KillCommand,
+		DeleteCommand,
+		PauseCommand,
+		ResumeCommand,
+		WaitCommand,
+	}
+	argv := append([]string{"rmica", "--root", root, "--mica-dir", srv.Dir}, args...)
+	err := app.Run(argv)
+	return out.String(), err
+}
+
+// stdout returns what f wrote to os.Stdout.
+func stdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	ferr := f()
+	os.Stdout = saved
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), ferr
+}
+
+func stateOf(t *testing.T, root string, srv *micadtest.Server) specs.State {
+	t.Helper()
+	out, err := stdout(t, func() error {
+		_, err := rmica(t, root, srv, "state", "zephyr01")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state specs.State
+	if err := json.Unmarshal([]byte(out), &state); err != nil {
+		t.Fatalf("state printed %q: %v", out, err)
+	}
+	return state
+}
+
+func count(calls []string, call string) int {
+	n := 0
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLifecycleCommands(t *testing.T) {
+	srv := newServer(t)
+	root := newRunning(t, srv).Root()
+
+	if st := stateOf(t, root, srv); st.Status != specs.StateRunning || st.ID != "zephyr01" {
+		t.Fatalf("state %+v", st)
+	}
+
+	for _, step := range []struct {
+		args   []string
+		status specs.ContainerState
+		client communication.ClientState
+	}{
+		{[]string{"pause", "zephyr01"}, "paused", communication.ClientSuspended},
+		{[]string{"resume", "zephyr01"}, specs.StateRunning, communication.ClientRunning},
+		{[]string{"kill", "zephyr01", "STOP"}, "paused", communication.ClientSuspended},
+		{[]string{"kill", "zephyr01", "CONT"}, specs.StateRunning, communication.ClientRunning},
+	} {
+		if _, err := rmica(t, root, srv, step.args...); err != nil {
+			t.Fatalf("rmica %s: %v", strings.Join(step.args, " "), err)
+		}
+		if st := stateOf(t, root, srv); st.Status != step.status {
+			t.Errorf("after %s the container is %s, want %s", step.args[0], st.Status, step.status)
+		}
+		if client, _ := srv.Client("zephyr01"); client.State != step.client {
+			t.Errorf("after %s the client is %s, want %s", step.args[0], client.State, step.client)
+		}
+	}
+
+	if _, err := rmica(t, root, srv, "delete", "zephyr01"); err == nil {
+		t.Error("deleted a running container")
+	}
+
+	if _, err := rmica(t, root, srv, "kill", "zephyr01", "KILL"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Client("zephyr01"); ok {
+		t.Error("kill KILL left the client behind")
+	}
+	out, err := rmica(t, root, srv, "wait", "zephyr01")
+	if err != nil || strings.TrimSpace(out) != "137" {
+		t.Errorf("wait printed %q, %v; want 137", out, err)
+	}
+	if _, err := rmica(t, root, srv, "delete", "zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "zephyr01")); !os.IsNotExist(err) {
+		t.Errorf("delete left the state dir: %v", err)
+	}
+	if n := count(srv.Calls(), "rm zephyr01"); n != 1 {
+		t.Errorf("micad was asked to remove the client %d times", n)
+	}
+}
+
+// wait returns when another rmica stops the client.
+func TestWaitForKill(t *testing.T) {
+	srv := newServer(t)
+	root := newRunning(t, srv).Root()
+
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := rmica(t, root, srv, "wait", "--interval", testInterval.String(), "zephyr01")
+		done <- result{out, err}
+	}()
+	time.Sleep(5 * testInterval)
+	if _, err := rmica(t, root, srv, "kill", "zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || strings.TrimSpace(r.out) != "143" {
+			t.Errorf("wait printed %q, %v; want 143", r.out, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait missed the kill")
+	}
+	if client, _ := srv.Client("zephyr01"); client.State != communication.ClientOffline {
+		t.Errorf("client is %s", client.State)
+	}
+}
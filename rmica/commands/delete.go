@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -11,18 +12,18 @@ import (
 	"golang.org/x/sys/unix"
 
 	"rmica/logger"
+	"rmica/mcs"
 	pseudo_container "rmica/pseudo-container"
 	"rmica/utils"
 )
 
-// TODO: handle mica task killing properly
 func killContainer(container *pseudo_container.Container) error {
-	ct := utils.GetMicaTaskConfig()
-	_ = container.Signal(unix.SIGKILL, *ct)
+	ct := mcs.ClientTask{Name: container.ClientName()}
+	_ = container.Signal(unix.SIGKILL, ct)
 
 	for range 100 {
 		time.Sleep(100 * time.Millisecond)
-		if err := container.Signal(unix.Signal(0), *ct); err != nil {
+		if err := container.Signal(unix.Signal(0), ct); err != nil {
 			return container.Destroy()
 		}
 	}
@@ -51,8 +52,8 @@ must be stopped before it can be deleted.`,
 		id := context.Args().First()
 		force := context.Bool("force")
 		cntr, err := pseudo_container.GetContainer(context)
-		containerDir := cntr.StateDir()
 		if err != nil {
+			containerDir := filepath.Join(context.GlobalString("root"), id)
 			logger.Debugf("deleting containerDir<%s>: %s", id, containerDir)
 			if errors.Is(err, utils.ErrNotExist) {
 				if e := os.RemoveAll(containerDir); e != nil {
@@ -0,0 +1,126 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+
+	"rmica/communication"
+	"rmica/logger"
+	"rmica/mcs"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var EventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "display client state changes and statistics of a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The events command polls micad for the client of the container and prints one
+JSON event per line: a "running", "paused", "stopped" or "crashed" event whenever
+the client changes state, and a "stats" event every interval. It returns after
+the client stopped or crashed, or once micad no longer knows the client.`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: 5 * time.Second,
+			Usage: "set the stats collection interval",
+		},
+		cli.BoolFlag{
+			Name:  "stats",
+			Usage: "display the container's stats then exit",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		interval := context.Duration("interval")
+		if interval <= 0 {
+			return errors.New("duration interval must be greater than 0")
+		}
+		if container.Status() == specs.StateStopped {
+			return fmt.Errorf("container with id %s is not running", container.Id())
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		if context.Bool("stats") {
+			s, err := container.Stats()
+			if err != nil {
+				return err
+			}
+			return enc.Encode(&mcs.Event{Type: mcs.EventStats, ID: container.Id(), Data: s})
+		}
+		return watchClient(container, interval, enc)
+	},
+}
+
+// watchClient polls micad every interval until the client stopped, crashed
+// or disappeared. The client of a created container is offline until start
+// boots it, that is no stop yet.
+func watchClient(container *pseudo_container.Container, interval time.Duration, enc *json.Encoder) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	id := container.Id()
+	started := container.Status() != specs.StateCreated
+	var last communication.ClientState
+	for ; ; <-ticker.C {
+		st, err := container.ClientStatus()
+		var failed *communication.FailedError
+		if errors.Is(err, communication.ErrConnRefused) || errors.As(err, &failed) {
+			// the client socket is gone or micad disowns the client
+			if last != communication.ClientOffline {
+				return enc.Encode(&mcs.Event{Type: mcs.EventStopped, ID: id})
+			}
+			return nil
+		}
+		if err != nil {
+			// micad may be busy or restarting, try again next tick
+			logger.Warnf("events %s: %v", id, err)
+			continue
+		}
+
+		booted := started || st.State != communication.ClientOffline
+		if st.State != last && booted {
+			last = st.State
+			if err := enc.Encode(&mcs.Event{Type: clientEventType(st.State), ID: id, Data: st}); err != nil {
+				return err
+			}
+		}
+		if st.State == communication.ClientCrashed || st.State == communication.ClientOffline && booted {
+			return nil
+		}
+		started = booted
+
+		s := pseudo_container.StatsFromStatus(st)
+		if err := enc.Encode(&mcs.Event{Type: mcs.EventStats, ID: id, Data: s}); err != nil {
+			return err
+		}
+	}
+}
+
+func clientEventType(s communication.ClientState) string {
+	switch s {
+	case communication.ClientRunning:
+		return mcs.EventRunning
+	case communication.ClientSuspended:
+		return mcs.EventPaused
+	case communication.ClientCrashed:
+		return mcs.EventCrashed
+	case communication.ClientOffline:
+		return mcs.EventStopped
+	}
+	return string(s)
+}
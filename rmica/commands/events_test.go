@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/communication/micadtest"
+	"rmica/mcs"
+	pseudo_container "rmica/pseudo-container"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const testInterval = 10 * time.Millisecond
+
+// newRunning returns container zephyr01 whose client srv booted.
+func newRunning(t *testing.T, srv *micadtest.Server) *pseudo_container.Container {
+	t.Helper()
+	c, err := pseudo_container.Create(t.TempDir(), "zephyr01", t.TempDir(), &specs.Spec{Version: specs.Version}, srv.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &communication.CreateMsg{CPU: 3}
+	copy(msg.Name[:], "zephyr01")
+	copy(msg.Path[:], "/lib/firmware/zephyr.elf")
+	if err := c.Register(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newServer(t *testing.T) *micadtest.Server {
+	t.Helper()
+	srv, err := micadtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// eventTypes lists the types of the events in out, stats left out.
+func eventTypes(t *testing.T, out []byte) []string {
+	t.Helper()
+	var types []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var ev mcs.Event
+		if err := dec.Decode(&ev); err == io.EOF {
+			return types
+		} else if err != nil {
+			t.Fatalf("bad event in %q: %v", out, err)
+		}
+		if ev.ID != "zephyr01" {
+			t.Errorf("event for %q", ev.ID)
+		}
+		if ev.Type != mcs.EventStats {
+			types = append(types, ev.Type)
+		}
+	}
+}
+
+// events returns once the client reached a state it does not leave.
+func TestWatchClientReturns(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		end  func(*micadtest.Server) error
+		want []string
+	}{
+		{
+			name: "crash",
+			end:  func(srv *micadtest.Server) error { return srv.Crash("zephyr01") },
+			want: []string{mcs.EventRunning, mcs.EventCrashed},
+		},
+		{
+			name: "vanish",
+			end:  func(srv *micadtest.Server) error { return srv.Vanish("zephyr01") },
+			want: []string{mcs.EventRunning, mcs.EventStopped},
+		},
+		{
+			name: "offline",
+			end: func(srv *micadtest.Server) error {
+				return srv.SetState("zephyr01", communication.ClientOffline)
+			},
+			want: []string{mcs.EventRunning, mcs.EventStopped},
+		},
+		{
+			name: "pause then crash",
+			end: func(srv *micadtest.Server) error {
+				if err := srv.SetState("zephyr01", communication.ClientSuspended); err != nil {
+					return err
+				}
+				time.Sleep(5 * testInterval)
+				return srv.Crash("zephyr01")
+			},
+			want: []string{mcs.EventRunning, mcs.EventPaused, mcs.EventCrashed},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newServer(t)
+			c := newRunning(t, srv)
+
+			var out bytes.Buffer
+			done := make(chan error, 1)
+			go func() { done <- watchClient(c, testInterval, json.NewEncoder(&out)) }()
+			time.Sleep(5 * testInterval)
+			if err := tc.end(srv); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("events did not return")
+			}
+			got := eventTypes(t, out.Bytes())
+			if len(got) != len(tc.want) {
+				t.Fatalf("got events %q, want %q", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got events %q, want %q", got, tc.want)
+				}
+			}
+		})
+	}
+}
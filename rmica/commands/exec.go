@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+
+	"rmica/logger"
+	"rmica/monitor"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var ExecCommand = cli.Command{
+	Name:  "exec",
+	Usage: "execute new task inside the client OS of a container",
+	ArgsUsage: `<container-id> <command> [command options]  || -p process.json <container-id>
+
+Where "<container-id>" is the name for the instance of the container and
+"<command>" is the command to be executed in the client.
+
+EXAMPLE:
+For example, if the container is configured to run the linux ps command the
+following will output a list of tasks running in the client:
+
+       # rmica exec <container-id> ps`,
+	Description: `The exec command asks micad to start a task on the running client of the
+container, from the args, env and cwd of an OCI process. The task is tracked
+in the state directory of the container. Unless detached, rmica waits for the
+task to end and exits with its exit status.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "process, p",
+			Usage: "path to the process.json",
+		},
+		cli.StringFlag{
+			Name:  "cwd",
+			Usage: "current working directory in the client",
+		},
+		cli.StringSliceFlag{
+			Name:  "env, e",
+			Usage: "set environment variables",
+		},
+		cli.BoolFlag{
+			Name:  "tty, t",
+			Usage: "allocate a pseudo-TTY",
+		},
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "detach from the task, print its id and exit",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: monitor.DefaultInterval,
+			Usage: "how often micad is asked whether the task ended",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.MinArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		process, err := getProcess(context, container)
+		if err != nil {
+			return err
+		}
+
+		p, err := container.ExecTask(process)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("exec %s: task %d started", container.Id(), p.TaskID)
+		if context.Bool("detach") {
+			fmt.Fprintln(context.App.Writer, p.TaskID)
+			return nil
+		}
+
+		exit, err := waitExec(container, p, context.Duration("interval"))
+		if err != nil {
+			return err
+		}
+		os.Exit(exit.Status)
+		return nil
+	},
+	SkipArgReorder: true,
+}
+
+// getProcess reads the process to exec from --process, or builds it from
+// the command line on top of the process of the container's spec.
+func getProcess(context *cli.Context, container *pseudo_container.Container) (*specs.Process, error) {
+	if path := context.String("process"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var p specs.Process
+		if err := json.NewDecoder(f).Decode(&p); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		return &p, nil
+	}
+
+	p := &specs.Process{}
+	if spec := container.Config(); spec != nil && spec.Process != nil {
+		*p = *spec.Process
+		p.Env = append([]string(nil), spec.Process.Env...)
+	}
+	p.Args = context.Args().Tail()
+	if len(p.Args) == 0 {
+		return nil, errors.New("exec args cannot be empty")
+	}
+	if cwd := context.String("cwd"); cwd != "" {
+		p.Cwd = cwd
+	}
+	p.Env = append(p.Env, context.StringSlice("env")...)
+	p.Terminal = context.Bool("tty")
+	return p, nil
+}
+
+func waitExec(container *pseudo_container.Container, p *pseudo_container.ExecProcess, interval time.Duration) (*monitor.Exit, error) {
+	return container.WaitExec(context.Background(), p, interval)
+}
@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-spec/specs-go/features"
+	"github.com/urfave/cli"
+
+	"rmica/defs"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+// annotations of features.json describing the mica side of rmica
+const (
+	featuresAnnoVersion     = defs.MicaAnnotationPrefix + "features.version"
+	featuresAnnoAnnotations = defs.MicaAnnotationPrefix + "features.annotations"
+	featuresAnnoRequired    = defs.MicaAnnotationPrefix + "features.annotations.required"
+	featuresAnnoPedestals   = defs.MicaAnnotationPrefix + "features.pedestals"
+	featuresAnnoIgnored     = defs.MicaAnnotationPrefix + "features.ignored"
+)
+
+// ignoredSections are the parts of config.json a mica client has no use for,
+// the client runs on a cpu of its own and not in a namespace of the host.
+var ignoredSections = []string{
+	"mounts",
+	"process.user",
+	"process.capabilities",
+	"process.rlimits",
+	"process.apparmorProfile",
+	"process.selinuxLabel",
+	"linux.namespaces",
+	"linux.uidMappings",
+	"linux.gidMappings",
+	"linux.devices",
+	"linux.cgroupsPath",
+	"linux.resources.devices",
+	"linux.resources.memory",
+	"linux.resources.cpu.shares",
+	"linux.resources.cpu.quota",
+	"linux.resources.cpu.burst",
+	"linux.resources.cpu.period",
+	"linux.resources.cpu.realtimeRuntime",
+	"linux.resources.cpu.realtimePeriod",
+	"linux.resources.cpu.mems",
+	"linux.resources.cpu.idle",
+	"linux.resources.pids",
+	"linux.resources.blockIO",
+	"linux.resources.hugepageLimits",
+	"linux.resources.network",
+	"linux.resources.rdma",
+	"linux.resources.unified",
+	"linux.seccomp",
+	"linux.sysctl",
+	"linux.maskedPaths",
+	"linux.readonlyPaths",
+	"linux.mountLabel",
+	"linux.intelRdt",
+}
+
+// featuresJSON keeps mountOptions in the output even though it is empty:
+// rmica mounts nothing and recognizes no mount option, which a missing
+// mountOptions would leave unknown.
+type featuresJSON struct {
+	MountOptions []string `json:"mountOptions"`
+	features.Features
+}
+
+var FeaturesCommand = cli.Command{
+	Name:      "features",
+	Usage:     "show the enabled features",
+	ArgsUsage: "",
+	Description: `Show the enabled features.
+The result is parsable as the OCI features.json, see the features schema in
+runtime-specs-Chinese/schema.
+
+The linux features are all disabled, a mica client does not run in the
+namespaces and cgroups of the host. The "annotations" object lists, under the
+` + defs.MicaAnnotationPrefix + `features.* keys, the config.json
+annotations rmica recognizes, the pedestals micad can boot a client on and
+the sections of config.json rmica ignores, each as a comma separated list.
+Of linux.resources, only cpu.cpus is not ignored: "rmica update" moves the
+client to that cpu. "mountOptions" is empty, mounts are ignored.`,
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 0, utils.ExactArgs); err != nil {
+			return err
+		}
+
+		disabled := false
+
+		annotations := make([]string, 0, len(utils.MicaAnnotations))
+		required := []string{}
+		for k, must := range utils.MicaAnnotations {
+			annotations = append(annotations, k)
+			if must {
+				required = append(required, k)
+			}
+		}
+		sort.Strings(annotations)
+		sort.Strings(required)
+
+		feat := features.Features{
+			OCIVersionMin: "1.0.0",
+			OCIVersionMax: specs.Version,
+			Hooks:         pseudo_container.SupportedHooks,
+			Linux: &features.Linux{
+				Cgroup: &features.Cgroup{
+					V1:          &disabled,
+					V2:          &disabled,
+					Systemd:     &disabled,
+					SystemdUser: &disabled,
+					Rdma:        &disabled,
+				},
+				Seccomp: &features.Seccomp{
+					Enabled: &disabled,
+				},
+				Apparmor: &features.Apparmor{
+					Enabled: &disabled,
+				},
+				Selinux: &features.Selinux{
+					Enabled: &disabled,
+				},
+				IntelRdt: &features.IntelRdt{
+					Enabled: &disabled,
+				},
+				MountExtensions: &features.MountExtensions{
+					IDMap: &features.IDMap{
+						Enabled: &disabled,
+					},
+				},
+			},
+			Annotations: map[string]string{
+				featuresAnnoVersion:     context.App.Version,
+				featuresAnnoAnnotations: strings.Join(annotations, ","),
+				featuresAnnoRequired:    strings.Join(required, ","),
+				featuresAnnoPedestals:   strings.Join(utils.MicaPedestals, ","),
+				featuresAnnoIgnored:     strings.Join(ignoredSections, ","),
+			},
+		}
+
+		enc := json.NewEncoder(context.App.Writer)
+		enc.SetIndent("", "    ")
+		return enc.Encode(featuresJSON{MountOptions: []string{}, Features: feat})
+	},
+}
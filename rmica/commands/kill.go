@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"github.com/urfave/cli"
+
+	"rmica/logger"
+	"rmica/mcs"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var KillCommand = cli.Command{
+	Name:  "kill",
+	Usage: "kill sends the specified signal (default: SIGTERM) to the container's client OS",
+	ArgsUsage: `<container-id> [signal]
+
+Where "<container-id>" is the name for the instance of the container and
+"[signal]" is the signal to be sent to the client OS.
+
+Signals are translated into micad operations:
+  SIGTERM          stop the client
+  SIGKILL          stop and remove the client
+  SIGSTOP/SIGTSTP  pause the client
+  SIGCONT          resume the client
+  0                check that micad still knows the client
+
+EXAMPLE:
+For example, if the container id is "zephyr01" the following will stop and
+remove the client of the "zephyr01" container:
+
+       # rmica kill zephyr01 KILL`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "accepted for compatibility with runc and ignored, the signal always reaches the whole client OS",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.MinArgs); err != nil {
+			return err
+		}
+		if err := utils.CheckArgs(context, 2, utils.MaxArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+
+		sigstr := context.Args().Get(1)
+		if sigstr == "" {
+			sigstr = "SIGTERM"
+		}
+		signal, err := utils.ParseSignal(sigstr)
+		if err != nil {
+			return err
+		}
+
+		ct := mcs.ClientTask{Name: container.ClientName()}
+		logger.Debugf("kill %s: signal %s -> client %s", container.Id(), signal, ct.Name)
+		return container.Signal(signal, ct)
+	},
+}
@@ -4,61 +4,192 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 
+	"rmica/defs"
 	"rmica/logger"
+	pseudo_container "rmica/pseudo-container"
 	"rmica/utils"
 )
 
+// containerState is what list prints for a container, the fields of runc's
+// list followed by those of the client.
+type containerState struct {
+	// Version is the OCI version for the container
+	Version string `json:"ociVersion"`
+	// ID is the container ID
+	ID string `json:"id"`
+	// InitProcessPid is the host-side handler of the client
+	InitProcessPid int `json:"pid"`
+	// Status is the current status of the container, running, paused, ...
+	Status string `json:"status"`
+	// Bundle is the path on the filesystem to the bundle
+	Bundle string `json:"bundle"`
+	// Created is the unix timestamp for the creation time of the container in UTC
+	Created time.Time `json:"created"`
+	// Annotations is the user defined annotations added to the config.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// The owner of the state directory (the owner of the container).
+	Owner string `json:"owner"`
+	// ClientName is the name micad knows the client by
+	ClientName string `json:"clientName"`
+	// CPU is the cpu the client runs on
+	CPU string `json:"cpu,omitempty"`
+	// Pedestal is the pedestal of the client, empty on bare metal
+	Pedestal string `json:"pedestal,omitempty"`
+	// MicadStatus is the state micad reports for the client right now,
+	// empty if the container is stopped or micad cannot tell
+	MicadStatus string `json:"micadStatus,omitempty"`
+}
+
 var ListCommand = cli.Command{
 	Name:  "list",
-	Usage: "list containers",
-	Description: `The list command lists all containers.`,
-	Action: func(context *cli.Context) error {
-		// Get root directory
-		root := utils.GetRootDir(context)
+	Usage: "lists containers started by rmica with the given root",
+	ArgsUsage: `
+
+Where the given root is specified via the global option "--root"
+(default: "` + defs.Root + `").
+
+EXAMPLE 1:
+To list containers created via the default "--root":
+       # rmica list
 
-		// Read container directories
-		entries, err := os.ReadDir(root)
+EXAMPLE 2:
+To list containers created using a non-default value for "--root":
+       # rmica --root value list`,
+	Description: `The list command lists all containers along with their clients: the client
+name, its cpu and pedestal from the mica annotations, and the status micad
+reports for the client right now.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "table",
+			Usage: `select one of: table or json (default: "table")`,
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "display only container IDs",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 0, utils.ExactArgs); err != nil {
+			return err
+		}
+		s, err := getContainers(context)
 		if err != nil {
-			if os.IsNotExist(err) {
-				return nil // No containers exist yet
-			}
-			return fmt.Errorf("failed to read container directory: %w", err)
+			return err
 		}
 
-		// Print container information
-		for _, entry := range entries {
-			if !entry.IsDir() {
-				continue
+		if context.Bool("quiet") {
+			for _, item := range s {
+				fmt.Fprintln(context.App.Writer, item.ID)
 			}
+			return nil
+		}
 
-			// Read state file
-			stateFile := filepath.Join(root, entry.Name(), "state.json")
-			f, err := os.Open(stateFile)
-			if err != nil {
-				logger.Errorf("failed to read state file for container %s: %v", entry.Name(), err)
-				continue
+		switch context.String("format") {
+		case "table":
+			w := tabwriter.NewWriter(context.App.Writer, 12, 1, 3, ' ', 0)
+			fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER\tCLIENT\tCPU\tPEDESTAL\tMICAD\n")
+			for _, item := range s {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					item.ID,
+					item.InitProcessPid,
+					item.Status,
+					item.Bundle,
+					item.Created.Format(time.RFC3339Nano),
+					item.Owner,
+					item.ClientName,
+					orDash(item.CPU),
+					orDash(item.Pedestal),
+					orDash(item.MicadStatus))
+			}
+			return w.Flush()
+		case "json":
+			if s == nil {
+				s = []containerState{}
 			}
+			return json.NewEncoder(context.App.Writer).Encode(s)
+		default:
+			return fmt.Errorf("invalid format option %q", context.String("format"))
+		}
+	},
+}
+
+func getContainers(context *cli.Context) ([]containerState, error) {
+	root := utils.GetRootDir(context)
+	list, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// no container created yet
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read container directory: %w", err)
+	}
 
-			var state specs.State
-			if err := json.NewDecoder(f).Decode(&state); err != nil {
-				f.Close()
-				logger.Errorf("failed to decode state file for container %s: %v", entry.Name(), err)
+	var s []containerState
+	for _, item := range list {
+		if !item.IsDir() {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(root, item.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the container was deleted meanwhile
 				continue
 			}
-			f.Close()
+			return nil, err
+		}
+		// this cast is safe on Linux
+		uid := st.Sys().(*syscall.Stat_t).Uid
+		owner, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+		ownerName := "#" + strconv.FormatUint(uint64(uid), 10)
+		if err == nil {
+			ownerName = owner.Username
+		}
 
-			// Print container information
-			logger.Infof("ID: %s", state.ID)
-			logger.Infof("Status: %s", state.Status)
-			logger.Infof("Bundle: %s", state.Bundle)
-			fmt.Println()
+		container, err := pseudo_container.LoadContainer(context, item.Name())
+		if err != nil {
+			logger.Warnf("failed to load container %s: %v", item.Name(), err)
+			continue
+		}
+		state := container.State()
+		cs := containerState{
+			Version:        state.Version,
+			ID:             state.ID,
+			InitProcessPid: state.Pid,
+			Status:         string(state.Status),
+			Bundle:         state.Bundle,
+			Created:        container.Created(),
+			Annotations:    state.Annotations,
+			Owner:          ownerName,
+			ClientName:     container.ClientName(),
+			CPU:            state.Annotations[defs.MicaAnnoClientCPU],
+			Pedestal:       state.Annotations[defs.MicaAnnoPedestal],
 		}
+		if state.Status != specs.StateStopped {
+			if st, err := container.ClientStatus(); err == nil {
+				cs.MicadStatus = string(st.State)
+			} else {
+				logger.Debugf("cannot get the status of client %s: %v", cs.ClientName, err)
+			}
+		}
+		s = append(s, cs)
+	}
+	return s, nil
+}
 
-		return nil
-	},
-} 
\ No newline at end of file
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
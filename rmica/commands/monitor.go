@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"github.com/urfave/cli"
+
+	"rmica/monitor"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+// MonitorCommand is spawned by create to wait on exec.fifo until start, it
+// is not meant to be run by hand.
+var MonitorCommand = cli.Command{
+	Name:   "monitor",
+	Usage:  "wait for a created container to be started and its client to exit (internal)",
+	Hidden: true,
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The monitor command blocks on the exec.fifo of a created container until start
+opens it, then waits for the client start booted to exit and records the exit
+in the container state.`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: monitor.DefaultInterval,
+			Usage: "how often micad is asked for the client status",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		return container.Monitor(context.Duration("interval"))
+	},
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"github.com/urfave/cli"
+
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var PauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "pause suspends the client OS of a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container to be
+paused.`,
+	Description: `The pause command asks micad to suspend the client of the container and
+records the container as "paused". Only a created or running container can be
+paused.`,
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		return container.Pause()
+	},
+}
+
+var ResumeCommand = cli.Command{
+	Name:  "resume",
+	Usage: "resumes the client OS of a paused container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container to be
+resumed.`,
+	Description: `The resume command asks micad to wake up the suspended client of a paused
+container and records the container as "running" again.`,
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		return container.Resume()
+	},
+}
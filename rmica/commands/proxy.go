@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+// ProxyCommand is spawned by create to keep the console of a client
+// attached once create returned, it is not meant to be run by hand.
+var ProxyCommand = cli.Command{
+	Name:   "proxy",
+	Usage:  "proxy the console of a client (internal)",
+	Hidden: true,
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The proxy command copies between the console of the client and, with --tty,
+the pty on fd 3 whose master went to the console socket, or else its own
+stdin and stdout, until the container stops.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "tty",
+			Usage: "proxy the pty on fd 3",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: time.Second,
+			Usage: "how often to look for the client console while there is none",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		if context.Bool("tty") {
+			return container.ProxyConsole(os.NewFile(3, "tty"), context.Duration("interval"))
+		}
+		return container.ForwardStdio(os.Stdin, os.Stdout, context.Duration("interval"))
+	},
+}
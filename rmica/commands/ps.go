@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+
+	"rmica/communication"
+	"rmica/logger"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var PsCommand = cli.Command{
+	Name:  "ps",
+	Usage: "ps displays the tasks running on the client OS of a container",
+	ArgsUsage: `<container-id> [ps options]
+
+Where "<container-id>" is the name for the instance of the container and
+"[ps options]" are passed to the host's ps when micad cannot list the tasks.`,
+	Description: `The ps command asks micad for the tasks running on the client of the container.
+If micad cannot list tasks (a micad without "ps" in its handshake, or the
+legacy protocol), the host-side handler process of the client is shown
+instead, the way runc shows the processes of a container.
+
+With --format json only the host pids of the container are printed, as a
+JSON array of integers like runc does, which is what containerd and docker
+top expect: the pid of the monitor of the client while it runs. The tasks
+of the client are only shown in the table.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "table",
+			Usage: `select one of: table or json (default: "table")`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.MinArgs); err != nil {
+			return err
+		}
+		format := context.String("format")
+		if format != "table" && format != "json" {
+			return fmt.Errorf("invalid format option %q", format)
+		}
+		if format == "json" && context.NArg() > 1 {
+			return errors.New("ps options are not supported with --format json")
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		if container.Status() == specs.StateStopped {
+			return fmt.Errorf("container with id %s is not running", container.Id())
+		}
+		if format == "json" {
+			pids, err := container.ClientProcesses()
+			if err != nil {
+				return err
+			}
+			return json.NewEncoder(context.App.Writer).Encode(pids)
+		}
+
+		tasks, err := container.Tasks()
+		switch {
+		case err == nil:
+			if context.NArg() > 1 {
+				logger.Warnf("ps options %v are ignored for the tasks of client %s",
+					context.Args().Tail(), container.ClientName())
+			}
+			return printTasks(context, tasks)
+		case errors.Is(err, communication.ErrNotSupported):
+			logger.Debugf("ps %s: %v, showing the handler process", container.Id(), err)
+			return printHandler(context, container)
+		default:
+			return err
+		}
+	},
+}
+
+func printTasks(context *cli.Context, tasks []communication.Task) error {
+	w := tabwriter.NewWriter(context.App.Writer, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "TID\tNAME\tSTATE\n")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", t.ID, t.Name, t.State)
+	}
+	return w.Flush()
+}
+
+// printHandler shows the host-side handler of the client like runc's ps
+// shows the processes of a container: the lines of the host's ps that
+// belong to it.
+func printHandler(context *cli.Context, container *pseudo_container.Container) error {
+	pids, err := container.ClientProcesses()
+	if err != nil {
+		return err
+	}
+
+	psArgs := context.Args().Tail()
+	if len(psArgs) == 0 {
+		psArgs = []string{"-ef"}
+	}
+	output, err := exec.Command("ps", psArgs...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%w: %s", err, exitErr.Stderr)
+		}
+		return err
+	}
+
+	lines := strings.Split(string(output), "\n")
+	pidIndex, err := getPidIndex(lines[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(context.App.Writer, lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) <= pidIndex {
+			continue
+		}
+		p, err := strconv.Atoi(fields[pidIndex])
+		if err != nil {
+			return fmt.Errorf("unable to parse pid: %w", err)
+		}
+		for _, pid := range pids {
+			if pid == p {
+				fmt.Fprintln(context.App.Writer, line)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func getPidIndex(title string) (int, error) {
+	for i, name := range strings.Fields(title) {
+		if name == "PID" {
+			return i, nil
+		}
+	}
+	return -1, errors.New("couldn't find PID field in ps output")
+}
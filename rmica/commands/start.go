@@ -45,8 +45,7 @@ your host.`,
 			if notifySocket != nil {
 				return notifySocket.WaitForContainer(container)
 			}
-			// TODO: zero parameter is not allowed
-			return container.Start()
+			return nil
 		case specs.StateStopped:
 			return errors.New("cannot start a container that has stopped")
 		case specs.StateRunning:
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var UpdateCommand = cli.Command{
+	Name:      "update",
+	Usage:     "update container resource constraints",
+	ArgsUsage: `<container-id>`,
+	Description: `The update command moves the client of a container to another cpu. micad
+migrates the client if it can, otherwise the client is created again on the
+new cpu and booted if it was running. The cpu must be online and must not be
+used by the client of another container.
+
+The accepted format for --resources is the OCI linux resources as JSON, of
+which only cpu.cpus applies to a mica client:
+
+{
+  "cpu": {
+    "cpus": "3"
+  }
+}
+
+Other resources are ignored. --cpuset-cpus takes precedence over --resources.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "resources, r",
+			Value: "",
+			Usage: `path to a file containing the resources to update or '-' to read from the standard input`,
+		},
+		cli.StringFlag{
+			Name:  "cpuset-cpus",
+			Usage: "the cpu the client runs on, e.g. 3",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+
+		r := &specs.LinuxResources{}
+		if in := context.String("resources"); in != "" {
+			var f io.Reader
+			if in == "-" {
+				f = os.Stdin
+			} else {
+				file, err := os.Open(in)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				f = file
+			}
+			if err := json.NewDecoder(f).Decode(r); err != nil {
+				return fmt.Errorf("failed to decode resources: %w", err)
+			}
+		}
+		if cpus := context.String("cpuset-cpus"); cpus != "" {
+			if r.CPU == nil {
+				r.CPU = &specs.LinuxCPU{}
+			}
+			r.CPU.Cpus = cpus
+		}
+		if r.CPU == nil || r.CPU.Cpus == "" {
+			return errors.New("no cpu to update, use --cpuset-cpus or cpu.cpus in --resources")
+		}
+		return container.Update(r)
+	},
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"rmica/monitor"
+	pseudo_container "rmica/pseudo-container"
+	"rmica/utils"
+)
+
+var WaitCommand = cli.Command{
+	Name:  "wait",
+	Usage: "wait for the client of a container to exit and print its exit status",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The wait command blocks until the client OS of the container stops, records
+the exit in the container state and prints the exit status. A client stopped by
+"rmica kill" exits with 128 plus the signal number, a client that went offline
+on its own with 0 and a crashed client with 1.`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: monitor.DefaultInterval,
+			Usage: "how often micad is asked for the client status",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "give up after waiting this long, 0 waits forever",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := utils.CheckArgs(context, 1, utils.ExactArgs); err != nil {
+			return err
+		}
+		container, err := pseudo_container.GetContainer(context)
+		if err != nil {
+			return err
+		}
+		interval := context.Duration("interval")
+		if interval <= 0 {
+			return errors.New("duration interval must be greater than 0")
+		}
+
+		exit, err := waitContainer(container, interval, context.Duration("timeout"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(context.App.Writer, exit.Status)
+		return nil
+	},
+}
+
+func waitContainer(container *pseudo_container.Container, interval, timeout time.Duration) (*monitor.Exit, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return container.Wait(ctx, interval)
+}
@@ -0,0 +1,563 @@
+package communication
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rmica/defs"
+	"rmica/logger"
+)
+
+// ClientState is the lifecycle state of a client as reported by micad.
+type ClientState string
+
+const (
+	ClientOffline   ClientState = "offline"
+	ClientRunning   ClientState = "running"
+	ClientSuspended ClientState = "suspended"
+	ClientCrashed   ClientState = "crashed"
+)
+
+// Reply is a successful answer of micad.
+type Reply struct {
+	// Output is what micad printed before MICA-SUCCESS, e.g. a gdb hint.
+	Output string
+}
+
+// ClientStatus is one line of `status`:
+// <name> <assigned cpu> <state> [service...]
+type ClientStatus struct {
+	Name    string
+	CPU     uint32
+	State   ClientState
+	Service string
+}
+
+func (s *ClientStatus) Running() bool {
+	return s.State == ClientRunning
+}
+
+// TTY returns the host device of the tty service micad lists for the client,
+// /dev/ttyRPMSG0 for "tty(/dev/ttyRPMSG0)", or "" if there is none.
+func (s *ClientStatus) TTY() string {
+	for _, svc := range strings.Fields(s.Service) {
+		i := strings.IndexByte(svc, '(')
+		if i <= 0 || !strings.HasSuffix(svc, ")") {
+			continue
+		}
+		if strings.Contains(strings.ToLower(svc[:i]), "tty") {
+			return svc[i+1 : len(svc)-1]
+		}
+	}
+	return ""
+}
+
+// Task is one line of `ps`: <id> <name> <state>
+type Task struct {
+	ID    uint32 `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// ExecTask describes a task to start on a running client, the part of an
+// OCI process an RTOS can make sense of.
+type ExecTask struct {
+	Args     []string `json:"args"`
+	Env      []string `json:"env,omitempty"`
+	Cwd      string   `json:"cwd,omitempty"`
+	Terminal bool     `json:"terminal,omitempty"`
+}
+
+// ExecStatus is the reply of exec-status.
+type ExecStatus struct {
+	ID       uint32
+	Exited   bool
+	ExitCode int
+}
+
+// MicadClient is every operation rmica asks micad for. An operation gives
+// up when ctx is done or its timeout passed, whichever comes first.
+// Errors wrap ErrConnRefused, ErrTimeout, ErrMalformedReply, the error of
+// ctx or are a *FailedError.
+type MicadClient interface {
+	Create(ctx context.Context, msg *CreateMsg) (*Reply, error)
+	Start(ctx context.Context, name string) (*Reply, error)
+	Stop(ctx context.Context, name string) (*Reply, error)
+	Remove(ctx context.Context, name string) (*Reply, error)
+	Status(ctx context.Context, name string) (*ClientStatus, error)
+	// Clients reports the status of every client micad has.
+	Clients(ctx context.Context) ([]ClientStatus, error)
+	Pause(ctx context.Context, name string) (*Reply, error)
+	Resume(ctx context.Context, name string) (*Reply, error)
+	// Tasks fails with ErrNotSupported if micad cannot list tasks.
+	Tasks(ctx context.Context, name string) ([]Task, error)
+	// Exec and ExecStatus fail with ErrNotSupported if micad cannot
+	// start tasks on a client.
+	Exec(ctx context.Context, name string, task *ExecTask) (uint32, error)
+	ExecStatus(ctx context.Context, name string, id uint32) (*ExecStatus, error)
+	// Checkpoint and Restore fail with ErrNotSupported if micad cannot
+	// export the state of a client.
+	Checkpoint(ctx context.Context, name string) ([]byte, error)
+	Restore(ctx context.Context, name string, state []byte) (*Reply, error)
+	// Migrate fails with ErrNotSupported if micad cannot move a client,
+	// the client then has to be created again on the new cpu.
+	Migrate(ctx context.Context, name string, cpu uint32) (*Reply, error)
+}
+
+// Protocol selects how SocketClient frames its requests. Frames are opt-in:
+// a micad that predates them reads whatever arrives on mica-create.socket
+// as a packed CreateMsg, a hello frame included.
+type Protocol int
+
+const (
+	// ProtocolLegacy sends bare CreateMsg and command strings and looks
+	// for MICA-SUCCESS/MICA-FAILED in the reply.
+	ProtocolLegacy Protocol = iota
+	// ProtocolFramed sends length-prefixed frames, see frame.go.
+	ProtocolFramed
+	// ProtocolAuto asks micad with a hello frame and falls back to
+	// ProtocolLegacy if micad does not answer in kind. Only pick it for a
+	// micad known to ignore frames it cannot read.
+	ProtocolAuto
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolLegacy:
+		return "legacy"
+	case ProtocolFramed:
+		return "framed"
+	}
+	return "auto"
+}
+
+// ParseProtocol parses the name of a protocol as String returns it.
+func ParseProtocol(name string) (Protocol, error) {
+	switch name {
+	case "legacy":
+		return ProtocolLegacy, nil
+	case "framed":
+		return ProtocolFramed, nil
+	case "auto":
+		return ProtocolAuto, nil
+	}
+	return ProtocolLegacy, fmt.Errorf("unknown micad protocol %q, want legacy, framed or auto", name)
+}
+
+// ProtocolFromEnv is the protocol $RMICA_MICA_PROTOCOL selects, legacy if
+// it is unset or unknown.
+func ProtocolFromEnv() Protocol {
+	env := os.Getenv(defs.MicaProtocolEnv)
+	if env == "" {
+		return ProtocolLegacy
+	}
+	p, err := ParseProtocol(env)
+	if err != nil {
+		logger.Warnf("%s: %v", defs.MicaProtocolEnv, err)
+	}
+	return p
+}
+
+// SocketClient talks to micad over its unix sockets: CreateMsg goes to
+// mica-create.socket, control commands go to <name>.socket.
+type SocketClient struct {
+	Dir string
+	// Timeout bounds every operation, 0 picks the default of the
+	// operation from OpTimeouts.
+	Timeout  time.Duration
+	Protocol Protocol
+
+	mu sync.Mutex
+	// negotiated is what the handshake found
+	negotiated Protocol
+	shook      bool
+	caps       []string
+	lastID     atomic.Uint32
+}
+
+var _ MicadClient = (*SocketClient)(nil)
+
+// NewSocketClient returns a client for the micad sockets in dir, speaking the
+// protocol ProtocolFromEnv selects.
+func NewSocketClient(dir string) *SocketClient {
+	return &SocketClient{Dir: dir, Protocol: ProtocolFromEnv()}
+}
+
+// NewDefaultClient returns a client for the micad found by DefaultSocketDir.
+func NewDefaultClient() MicadClient {
+	return NewSocketClient(DefaultSocketDir())
+}
+
+func (s *SocketClient) Create(ctx context.Context, msg *CreateMsg) (*Reply, error) {
+	name := msg.ClientName()
+	out, err := s.roundTrip(ctx, OpCreate, msg.Pack(), s.createSocket())
+	if err != nil {
+		return nil, wrapOp("create", name, err)
+	}
+	return &Reply{Output: out}, nil
+}
+
+func (s *SocketClient) Start(ctx context.Context, name string) (*Reply, error) {
+	return s.ctrl(ctx, OpStart, name)
+}
+
+func (s *SocketClient) Stop(ctx context.Context, name string) (*Reply, error) {
+	return s.ctrl(ctx, OpStop, name)
+}
+
+func (s *SocketClient) Remove(ctx context.Context, name string) (*Reply, error) {
+	return s.ctrl(ctx, OpRemove, name)
+}
+
+func (s *SocketClient) Pause(ctx context.Context, name string) (*Reply, error) {
+	return s.ctrl(ctx, OpPause, name)
+}
+
+func (s *SocketClient) Resume(ctx context.Context, name string) (*Reply, error) {
+	return s.ctrl(ctx, OpResume, name)
+}
+
+// Status is idempotent and retried with backoff while micad restarts.
+func (s *SocketClient) Status(ctx context.Context, name string) (*ClientStatus, error) {
+	var st *ClientStatus
+	err := retry(ctx, statusAttempts, s.restarting, func() error {
+		reply, err := s.ctrl(ctx, OpStatus, name)
+		if err != nil {
+			return err
+		}
+		st, err = ParseStatus(name, reply.Output)
+		return err
+	})
+	return st, err
+}
+
+// Clients asks every client socket in Dir for its status, the way
+// `mica status` does. Sockets nobody listens on any more are skipped.
+func (s *SocketClient) Clients(ctx context.Context) ([]ClientStatus, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	clients := []ClientStatus{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".socket")
+		if !ok || entry.Name() == defs.MicaSocketName {
+			continue
+		}
+		st, err := s.Status(ctx, name)
+		if errors.Is(err, ErrConnRefused) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *st)
+	}
+	return clients, nil
+}
+
+// Tasks is idempotent and retried like Status.
+func (s *SocketClient) Tasks(ctx context.Context, name string) ([]Task, error) {
+	if !s.serves(ctx, OpTasks) {
+		return nil, wrapOp(OpTasks.String(), name, ErrNotSupported)
+	}
+	var tasks []Task
+	err := retry(ctx, statusAttempts, s.restarting, func() error {
+		reply, err := s.ctrl(ctx, OpTasks, name)
+		if err != nil {
+			return err
+		}
+		tasks, err = ParseTasks(reply.Output)
+		return err
+	})
+	return tasks, err
+}
+
+func (s *SocketClient) Exec(ctx context.Context, name string, task *ExecTask) (uint32, error) {
+	if !s.serves(ctx, OpExec) {
+		return 0, wrapOp(OpExec.String(), name, ErrNotSupported)
+	}
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return 0, wrapOp(OpExec.String(), name, err)
+	}
+	reply, err := s.request(ctx, OpExec, name, payload)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(reply.Output, 10, 32)
+	if err != nil {
+		return 0, wrapOp(OpExec.String(), name,
+			fmt.Errorf("%w: bad task id %q", ErrMalformedReply, reply.Output))
+	}
+	return uint32(id), nil
+}
+
+// ExecStatus is idempotent and retried like Status.
+func (s *SocketClient) ExecStatus(ctx context.Context, name string, id uint32) (*ExecStatus, error) {
+	if !s.serves(ctx, OpExecStatus) {
+		return nil, wrapOp(OpExecStatus.String(), name, ErrNotSupported)
+	}
+	var st *ExecStatus
+	err := retry(ctx, statusAttempts, s.restarting, func() error {
+		reply, err := s.request(ctx, OpExecStatus, name, []byte(strconv.FormatUint(uint64(id), 10)))
+		if err != nil {
+			return err
+		}
+		st, err = ParseExecStatus(id, reply.Output)
+		return err
+	})
+	return st, err
+}
+
+func (s *SocketClient) Checkpoint(ctx context.Context, name string) ([]byte, error) {
+	if !s.serves(ctx, OpCheckpoint) {
+		return nil, wrapOp(OpCheckpoint.String(), name, ErrNotSupported)
+	}
+	reply, err := s.ctrl(ctx, OpCheckpoint, name)
+	if err != nil {
+		return nil, err
+	}
+	state, err := base64.StdEncoding.DecodeString(reply.Output)
+	if err != nil {
+		return nil, wrapOp(OpCheckpoint.String(), name, fmt.Errorf("%w: %v", ErrMalformedReply, err))
+	}
+	return state, nil
+}
+
+func (s *SocketClient) Restore(ctx context.Context, name string, state []byte) (*Reply, error) {
+	if !s.serves(ctx, OpRestore) {
+		return nil, wrapOp(OpRestore.String(), name, ErrNotSupported)
+	}
+	return s.request(ctx, OpRestore, name, state)
+}
+
+func (s *SocketClient) Migrate(ctx context.Context, name string, cpu uint32) (*Reply, error) {
+	if !s.serves(ctx, OpMigrate) {
+		return nil, wrapOp(OpMigrate.String(), name, ErrNotSupported)
+	}
+	return s.request(ctx, OpMigrate, name, []byte(strconv.FormatUint(uint64(cpu), 10)))
+}
+
+func (s *SocketClient) ctrl(ctx context.Context, op Opcode, name string) (*Reply, error) {
+	return s.request(ctx, op, name, []byte(name))
+}
+
+// request sends op with payload to the control socket of client name.
+func (s *SocketClient) request(ctx context.Context, op Opcode, name string, payload []byte) (*Reply, error) {
+	out, err := s.roundTrip(ctx, op, payload, s.ctrlSocket(name))
+	if err != nil {
+		return nil, wrapOp(op.String(), name, err)
+	}
+	return &Reply{Output: out}, nil
+}
+
+// roundTrip sends one request in the protocol micad speaks. payload is the
+// packed CreateMsg for OpCreate and the client name otherwise.
+func (s *SocketClient) roundTrip(ctx context.Context, op Opcode, payload []byte, path string) (string, error) {
+	proto := s.protocol(ctx)
+	ctx, cancel := context.WithTimeout(ctx, s.timeout(op))
+	defer cancel()
+	if proto != ProtocolFramed {
+		data := payload
+		if op != OpCreate {
+			// a legacy control socket only understands the command
+			data = []byte(op.String())
+		}
+		return send2socket(ctx, data, path)
+	}
+	req := &Frame{Version: FrameVersion, Op: op, ID: s.lastID.Add(1), Payload: payload}
+	reply, err := sendFrame(ctx, req, path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(reply.Payload)), nil
+}
+
+// timeout is how long op may take.
+func (s *SocketClient) timeout(op Opcode) time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	if d, ok := OpTimeouts[op]; ok {
+		return d
+	}
+	return DefaultTimeout
+}
+
+// protocol returns the protocol to use, negotiating it on first use of
+// ProtocolAuto.
+func (s *SocketClient) protocol(ctx context.Context) Protocol {
+	if s.Protocol != ProtocolAuto {
+		return s.Protocol
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.shook {
+		p := s.handshake(ctx)
+		if p == ProtocolAuto {
+			// micad is not there yet, ask again next time
+			return ProtocolLegacy
+		}
+		s.negotiated, s.shook = p, true
+	}
+	return s.negotiated
+}
+
+// handshake sends a hello frame to mica-create.socket. It returns
+// ProtocolAuto when micad cannot be reached at all.
+func (s *SocketClient) handshake(ctx context.Context) Protocol {
+	ctx, cancel := context.WithTimeout(ctx, min(s.timeout(OpHello), OpTimeouts[OpHello]))
+	defer cancel()
+	req := &Frame{Version: FrameVersion, Op: OpHello, ID: s.lastID.Add(1)}
+	reply, err := sendFrame(ctx, req, s.createSocket())
+	if err != nil {
+		if errors.Is(err, ErrConnRefused) || errors.Is(err, context.Canceled) {
+			return ProtocolAuto
+		}
+		logger.Debugf("micad at %s does not speak frames (%v), using the legacy protocol", s.Dir, err)
+		return ProtocolLegacy
+	}
+	s.caps = parseCapabilities(reply.Payload)
+	logger.Debugf("micad at %s speaks frames v%d, capabilities %v", s.Dir, reply.Version, s.caps)
+	return ProtocolFramed
+}
+
+// restarting tells a request that may succeed once micad is back from one
+// that failed for good. A refused client socket only means micad is away
+// if mica-create.socket refuses as well, otherwise the client is gone.
+func (s *SocketClient) restarting(err error) bool {
+	if errors.Is(err, ErrTimeout) {
+		return true
+	}
+	if !errors.Is(err, ErrConnRefused) {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", s.createSocket(), pingTimeout)
+	if err != nil {
+		return true
+	}
+	conn.Close()
+	return false
+}
+
+// Capabilities lists the operations micad announced in the handshake,
+// nil for a legacy micad. ProtocolFramed shakes hands on the first call.
+func (s *SocketClient) Capabilities(ctx context.Context) []string {
+	if s.protocol(ctx) != ProtocolFramed {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.shook {
+		if p := s.handshake(ctx); p != ProtocolAuto {
+			s.negotiated, s.shook = p, true
+		}
+	}
+	return append([]string(nil), s.caps...)
+}
+
+// serves reports whether micad announced op in the handshake.
+func (s *SocketClient) serves(ctx context.Context, op Opcode) bool {
+	for _, c := range s.Capabilities(ctx) {
+		if c == op.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SocketClient) createSocket() string {
+	return filepath.Join(s.Dir, defs.MicaSocketName)
+}
+
+func (s *SocketClient) ctrlSocket(name string) string {
+	return filepath.Join(s.Dir, name+".socket")
+}
+
+// ParseTasks reads a ps reply, a header line is skipped.
+func ParseTasks(out string) ([]Task, error) {
+	tasks := []Task{}
+	for i, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil && i == 0 {
+			continue
+		}
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("%w: bad task line %q", ErrMalformedReply, line)
+		}
+		tasks = append(tasks, Task{
+			ID:    uint32(id),
+			Name:  fields[1],
+			State: strings.ToLower(strings.Join(fields[2:], " ")),
+		})
+	}
+	return tasks, nil
+}
+
+// ParseExecStatus reads an exec-status reply for task id.
+func ParseExecStatus(id uint32, out string) (*ExecStatus, error) {
+	fields := strings.Fields(out)
+	switch {
+	case len(fields) == 1 && fields[0] == "running":
+		return &ExecStatus{ID: id}, nil
+	case len(fields) == 2 && fields[0] == "exited":
+		code, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad exit code %q of task %d", ErrMalformedReply, fields[1], id)
+		}
+		return &ExecStatus{ID: id, Exited: true, ExitCode: code}, nil
+	}
+	return nil, fmt.Errorf("%w: bad status %q of task %d", ErrMalformedReply, out, id)
+}
+
+// ParseStatus picks the line describing client name out of a status reply.
+func ParseStatus(name, out string) (*ClientStatus, error) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		cpu, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad cpu %q for %s", ErrMalformedReply, fields[1], name)
+		}
+		return &ClientStatus{
+			Name:    name,
+			CPU:     uint32(cpu),
+			State:   ClientState(strings.ToLower(fields[2])),
+			Service: strings.Join(fields[3:], " "),
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: no status line for %s in %q", ErrMalformedReply, name, out)
+}
+
+// wrapOp fills in the request a *FailedError belongs to and adds context
+// to the other errors.
+func wrapOp(op, name string, err error) error {
+	if f, ok := err.(*FailedError); ok {
+		f.Op, f.Client = op, name
+		return f
+	}
+	logger.Debugf("micad %s %s: %v", op, name, err)
+	return fmt.Errorf("micad %s %s: %w", op, name, err)
+}
+
+func cString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
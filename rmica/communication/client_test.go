@@ -0,0 +1,88 @@
+package communication
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	out := "Name            CPU     State       Service\n" +
+		"uniproton       2       OFFLINE     \n" +
+		"zephyr01        3       RUNNING     tty(/dev/ttyRPMSG0) rpmsg-umt\n"
+	st, err := ParseStatus("zephyr01", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ClientStatus{Name: "zephyr01", CPU: 3, State: ClientRunning, Service: "tty(/dev/ttyRPMSG0) rpmsg-umt"}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+	if !st.Running() || st.TTY() != "/dev/ttyRPMSG0" {
+		t.Errorf("Running() = %v, TTY() = %q", st.Running(), st.TTY())
+	}
+
+	st, err = ParseStatus("uniproton", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != ClientOffline || st.Service != "" || st.Running() {
+		t.Errorf("uniproton: %+v", st)
+	}
+
+	for _, bad := range []string{"", out[:40], "zephyr01 x RUNNING"} {
+		if _, err := ParseStatus("zephyr01", bad); !errors.Is(err, ErrMalformedReply) {
+			t.Errorf("ParseStatus(%q) = %v, want ErrMalformedReply", bad, err)
+		}
+	}
+}
+
+func TestParseTasks(t *testing.T) {
+	tasks, err := ParseTasks("ID      Name                    State\n" +
+		"1       shell                   RUNNING\n" +
+		"\n" +
+		"4       sensor                  BLOCKED ON SEM\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Task{
+		{ID: 1, Name: "shell", State: "running"},
+		{ID: 4, Name: "sensor", State: "blocked on sem"},
+	}
+	if !reflect.DeepEqual(tasks, want) {
+		t.Errorf("got %+v, want %+v", tasks, want)
+	}
+
+	tasks, err = ParseTasks("")
+	if err != nil || tasks == nil || len(tasks) != 0 {
+		t.Errorf("empty reply: %v, %v", tasks, err)
+	}
+
+	for _, bad := range []string{"1 shell RUNNING\nshell RUNNING", "1 shell"} {
+		if _, err := ParseTasks(bad); !errors.Is(err, ErrMalformedReply) {
+			t.Errorf("ParseTasks(%q) = %v, want ErrMalformedReply", bad, err)
+		}
+	}
+}
+
+func TestParseExecStatus(t *testing.T) {
+	for out, want := range map[string]ExecStatus{
+		"running":   {ID: 2},
+		"exited 0":  {ID: 2, Exited: true},
+		"exited 13": {ID: 2, Exited: true, ExitCode: 13},
+	} {
+		st, err := ParseExecStatus(2, out)
+		if err != nil {
+			t.Errorf("ParseExecStatus(%q): %v", out, err)
+			continue
+		}
+		if *st != want {
+			t.Errorf("ParseExecStatus(%q) = %+v, want %+v", out, *st, want)
+		}
+	}
+	for _, bad := range []string{"", "exited", "exited -", "done 0"} {
+		if _, err := ParseExecStatus(2, bad); !errors.Is(err, ErrMalformedReply) {
+			t.Errorf("ParseExecStatus(%q) = %v, want ErrMalformedReply", bad, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package communication
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrConnRefused: nothing listens on the micad socket, micad is down
+	// or the client has not been created.
+	ErrConnRefused = errors.New("micad refused the connection")
+	// ErrTimeout: micad accepted the request but did not answer in time.
+	ErrTimeout = errors.New("timeout while waiting for micad response")
+	// ErrMalformedReply: micad answered without a verdict, or with a
+	// payload rmica cannot parse.
+	ErrMalformedReply = errors.New("malformed reply from micad")
+	// ErrNotSupported: micad does not serve the operation, e.g. a legacy
+	// micad asked for the task list.
+	ErrNotSupported = errors.New("operation not supported by micad")
+)
+
+// FailedError is returned when micad answers MICA-FAILED.
+// Diag holds whatever micad printed before the verdict.
+type FailedError struct {
+	Op     string
+	Client string
+	Diag   string
+}
+
+func (e *FailedError) Error() string {
+	msg := fmt.Sprintf("micad failed to %s %s", e.Op, e.Client)
+	if e.Diag != "" {
+		msg += ": " + e.Diag
+	}
+	return msg
+}
@@ -0,0 +1,249 @@
+package communication
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FakeClient is an in-memory MicadClient for tests. It keeps one
+// ClientStatus per created client in Known and records every call in Calls.
+// Errs["<op> <name>"] (or Errs["<op>"]) makes that operation fail.
+// TaskList[name] is what ps reports, a client without an entry makes ps
+// unsupported. Exec appends to Execs[name] and the task exits with
+// ExitCodes[id] (0 if unset) as soon as it is asked after. Checkpoint
+// returns States[name], a client without an entry makes checkpoint and
+// restore unsupported.
+type FakeClient struct {
+	mu        sync.Mutex
+	Known     map[string]*ClientStatus
+	TaskList  map[string][]Task
+	Execs     map[string][]ExecTask
+	ExitCodes map[uint32]int
+	States    map[string][]byte
+	Errs      map[string]error
+	Calls     []string
+}
+
+var _ MicadClient = (*FakeClient)(nil)
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		Known:     map[string]*ClientStatus{},
+		TaskList:  map[string][]Task{},
+		Execs:     map[string][]ExecTask{},
+		ExitCodes: map[uint32]int{},
+		States:    map[string][]byte{},
+		Errs:      map[string]error{},
+	}
+}
+
+func (f *FakeClient) Create(ctx context.Context, msg *CreateMsg) (*Reply, error) {
+	name := msg.ClientName()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "create", name); err != nil {
+		return nil, err
+	}
+	if _, ok := f.Known[name]; ok {
+		return nil, &FailedError{Op: "create", Client: name, Diag: "client already exists"}
+	}
+	f.Known[name] = &ClientStatus{Name: name, CPU: msg.CPU, State: ClientOffline}
+	return &Reply{}, nil
+}
+
+func (f *FakeClient) Start(ctx context.Context, name string) (*Reply, error) {
+	return f.move(ctx, "start", name, ClientRunning)
+}
+
+func (f *FakeClient) Stop(ctx context.Context, name string) (*Reply, error) {
+	return f.move(ctx, "stop", name, ClientOffline)
+}
+
+func (f *FakeClient) Pause(ctx context.Context, name string) (*Reply, error) {
+	return f.move(ctx, "pause", name, ClientSuspended)
+}
+
+func (f *FakeClient) Resume(ctx context.Context, name string) (*Reply, error) {
+	return f.move(ctx, "resume", name, ClientRunning)
+}
+
+func (f *FakeClient) Remove(ctx context.Context, name string) (*Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "rm", name); err != nil {
+		return nil, err
+	}
+	if _, err := f.lookup("rm", name); err != nil {
+		return nil, err
+	}
+	delete(f.Known, name)
+	return &Reply{}, nil
+}
+
+func (f *FakeClient) Status(ctx context.Context, name string) (*ClientStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "status", name); err != nil {
+		return nil, err
+	}
+	cs, err := f.lookup("status", name)
+	if err != nil {
+		return nil, err
+	}
+	st := *cs
+	return &st, nil
+}
+
+// Clients reports the known clients sorted by name.
+func (f *FakeClient) Clients(ctx context.Context) ([]ClientStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "clients", ""); err != nil {
+		return nil, err
+	}
+	clients := []ClientStatus{}
+	for _, cs := range f.Known {
+		clients = append(clients, *cs)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	return clients, nil
+}
+
+func (f *FakeClient) Tasks(ctx context.Context, name string) ([]Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "ps", name); err != nil {
+		return nil, err
+	}
+	if _, err := f.lookup("ps", name); err != nil {
+		return nil, err
+	}
+	tasks, ok := f.TaskList[name]
+	if !ok {
+		return nil, fmt.Errorf("micad ps %s: %w", name, ErrNotSupported)
+	}
+	return append([]Task(nil), tasks...), nil
+}
+
+func (f *FakeClient) Exec(ctx context.Context, name string, task *ExecTask) (uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "exec", name); err != nil {
+		return 0, err
+	}
+	cs, err := f.lookup("exec", name)
+	if err != nil {
+		return 0, err
+	}
+	if !cs.Running() {
+		return 0, &FailedError{Op: "exec", Client: name, Diag: "client is not running"}
+	}
+	f.Execs[name] = append(f.Execs[name], *task)
+	return uint32(len(f.Execs[name])), nil
+}
+
+func (f *FakeClient) ExecStatus(ctx context.Context, name string, id uint32) (*ExecStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "exec-status", name); err != nil {
+		return nil, err
+	}
+	if _, err := f.lookup("exec-status", name); err != nil {
+		return nil, err
+	}
+	if id == 0 || int(id) > len(f.Execs[name]) {
+		return nil, &FailedError{Op: "exec-status", Client: name, Diag: fmt.Sprintf("no task %d", id)}
+	}
+	return &ExecStatus{ID: id, Exited: true, ExitCode: f.ExitCodes[id]}, nil
+}
+
+func (f *FakeClient) Checkpoint(ctx context.Context, name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "checkpoint", name); err != nil {
+		return nil, err
+	}
+	if _, err := f.lookup("checkpoint", name); err != nil {
+		return nil, err
+	}
+	state, ok := f.States[name]
+	if !ok {
+		return nil, fmt.Errorf("micad checkpoint %s: %w", name, ErrNotSupported)
+	}
+	return append([]byte(nil), state...), nil
+}
+
+func (f *FakeClient) Restore(ctx context.Context, name string, state []byte) (*Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "restore", name); err != nil {
+		return nil, err
+	}
+	cs, err := f.lookup("restore", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.States[name]; !ok {
+		return nil, fmt.Errorf("micad restore %s: %w", name, ErrNotSupported)
+	}
+	f.States[name] = append([]byte(nil), state...)
+	cs.State = ClientRunning
+	return &Reply{}, nil
+}
+
+// Migrate is supported unless Errs says otherwise, e.g. with
+// ErrNotSupported.
+func (f *FakeClient) Migrate(ctx context.Context, name string, cpu uint32) (*Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "migrate", name); err != nil {
+		return nil, err
+	}
+	cs, err := f.lookup("migrate", name)
+	if err != nil {
+		return nil, err
+	}
+	cs.CPU = cpu
+	return &Reply{}, nil
+}
+
+func (f *FakeClient) move(ctx context.Context, op, name string, to ClientState) (*Reply, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, op, name); err != nil {
+		return nil, err
+	}
+	cs, err := f.lookup(op, name)
+	if err != nil {
+		return nil, err
+	}
+	cs.State = to
+	return &Reply{}, nil
+}
+
+// call records op and returns the error injected for it, if any.
+func (f *FakeClient) call(ctx context.Context, op, name string) error {
+	call := op
+	if name != "" {
+		call += " " + name
+	}
+	f.Calls = append(f.Calls, call)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("micad %s: %w", call, err)
+	}
+	if err, ok := f.Errs[call]; ok {
+		return err
+	}
+	return f.Errs[op]
+}
+
+func (f *FakeClient) lookup(op, name string) (*ClientStatus, error) {
+	cs, ok := f.Known[name]
+	if !ok {
+		// micad has no <name>.socket for unknown clients
+		return nil, fmt.Errorf("micad %s %s: %w", op, name, ErrConnRefused)
+	}
+	return cs, nil
+}
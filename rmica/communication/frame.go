@@ -0,0 +1,175 @@
+package communication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Framed protocol
+//
+// Every message is a 16 byte header followed by the payload:
+//
+//	magic   [4]byte  "MCAF"
+//	version uint8    FrameVersion
+//	opcode  uint8    Opcode
+//	flags   uint16   FlagReply, FlagFailed
+//	id      uint32   request id, echoed by the reply
+//	length  uint32   payload length
+//
+// Integers are little endian like in CreateMsg. A create request carries the
+// packed CreateMsg, a control request the client name (or, for OpExec,
+// OpExecStatus, OpRestore and OpMigrate, what they document), a reply the output of micad (or its
+// diagnostic, with FlagFailed). A packed CreateMsg can never start with
+// the magic, cpu 0x4641434d does not exist, and neither can a legacy
+// command or a legacy "MICA-..." verdict, so both sides tell the protocols
+// apart from the first four bytes.
+const (
+	FrameMagic     = "MCAF"
+	FrameVersion   = 1
+	FrameHeaderLen = 16
+	// MaxFramePayload bounds what a peer may announce in length.
+	MaxFramePayload = 1 << 20
+)
+
+// Opcode is the operation a frame requests.
+type Opcode uint8
+
+const (
+	// OpHello is the capability handshake: the reply lists the opcodes
+	// micad serves, separated by spaces.
+	OpHello Opcode = iota
+	OpCreate
+	OpStart
+	OpStop
+	OpRemove
+	OpStatus
+	OpPause
+	OpResume
+	// OpTasks lists the tasks of a client, one per line. Only a micad
+	// announcing "ps" in the handshake serves it.
+	OpTasks
+	// OpExec starts a task on a client, the payload is an ExecTask in
+	// JSON and the reply the id of the new task.
+	OpExec
+	// OpExecStatus asks after a task started by OpExec, the payload is
+	// its id and the reply "running" or "exited <code>".
+	OpExecStatus
+	// OpCheckpoint exports the state of a running or suspended client,
+	// the reply is that state, base64 encoded.
+	OpCheckpoint
+	// OpRestore boots a created client from a state OpCheckpoint
+	// exported, the payload is the raw state.
+	OpRestore
+	// OpMigrate moves a client to another cpu, the payload is the cpu.
+	OpMigrate
+)
+
+var opcodeNames = map[Opcode]string{
+	OpHello:      "hello",
+	OpCreate:     "create",
+	OpStart:      "start",
+	OpStop:       "stop",
+	OpRemove:     "rm",
+	OpStatus:     "status",
+	OpPause:      "pause",
+	OpResume:     "resume",
+	OpTasks:      "ps",
+	OpExec:       "exec",
+	OpExecStatus: "exec-status",
+	OpCheckpoint: "checkpoint",
+	OpRestore:    "restore",
+	OpMigrate:    "migrate",
+}
+
+// String returns the legacy command of the opcode.
+func (o Opcode) String() string {
+	if name, ok := opcodeNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("opcode(%d)", uint8(o))
+}
+
+// ParseOpcode maps a legacy command such as "rm" to its opcode.
+func ParseOpcode(cmd string) (Opcode, bool) {
+	for op, name := range opcodeNames {
+		if name == cmd {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+const (
+	FlagReply  uint16 = 1 << 0
+	FlagFailed uint16 = 1 << 1
+)
+
+// Frame is one message of the framed protocol.
+type Frame struct {
+	Version uint8
+	Op      Opcode
+	Flags   uint16
+	ID      uint32
+	Payload []byte
+}
+
+// ErrNotFramed is returned by ReadFrame when the peer does not speak the
+// framed protocol.
+var ErrNotFramed = errors.New("not a mica frame")
+
+// WriteFrame sends f in a single write.
+func WriteFrame(w io.Writer, f *Frame) error {
+	if len(f.Payload) > MaxFramePayload {
+		return fmt.Errorf("frame payload of %d bytes exceeds %d", len(f.Payload), MaxFramePayload)
+	}
+	buf := make([]byte, FrameHeaderLen, FrameHeaderLen+len(f.Payload))
+	copy(buf, FrameMagic)
+	buf[4] = f.Version
+	buf[5] = uint8(f.Op)
+	binary.LittleEndian.PutUint16(buf[6:], f.Flags)
+	binary.LittleEndian.PutUint32(buf[8:], f.ID)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(f.Payload)))
+	buf = append(buf, f.Payload...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads one frame, however many reads it takes.
+func ReadFrame(r io.Reader) (*Frame, error) {
+	head := make([]byte, FrameHeaderLen)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if string(head[:4]) != FrameMagic {
+		return nil, fmt.Errorf("%w: starts with %q", ErrNotFramed, head[:4])
+	}
+	if head[4] == 0 || head[4] > FrameVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrNotFramed, head[4])
+	}
+	f := &Frame{
+		Version: head[4],
+		Op:      Opcode(head[5]),
+		Flags:   binary.LittleEndian.Uint16(head[6:]),
+		ID:      binary.LittleEndian.Uint32(head[8:]),
+	}
+	length := binary.LittleEndian.Uint32(head[12:])
+	if length > MaxFramePayload {
+		return nil, fmt.Errorf("%w: payload of %d bytes exceeds %d", ErrMalformedReply, length, MaxFramePayload)
+	}
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: frame cut after %d bytes", ErrMalformedReply, FrameHeaderLen)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseCapabilities reads the payload of a hello reply.
+func parseCapabilities(payload []byte) []string {
+	return strings.Fields(string(payload))
+}
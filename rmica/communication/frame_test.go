@@ -0,0 +1,93 @@
+package communication
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, f := range []*Frame{
+		{Version: FrameVersion, Op: OpHello, ID: 1},
+		{Version: FrameVersion, Op: OpStatus, Flags: FlagReply, ID: 7, Payload: []byte("zephyr01 3 RUNNING tty(/dev/ttyRPMSG0)")},
+		{Version: FrameVersion, Op: OpStart, Flags: FlagReply | FlagFailed, ID: 1 << 31, Payload: []byte("no such firmware")},
+	} {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, f); err != nil {
+			t.Fatalf("WriteFrame(%v): %v", f.Op, err)
+		}
+		if buf.Len() != FrameHeaderLen+len(f.Payload) {
+			t.Errorf("%v: wrote %d bytes, want %d", f.Op, buf.Len(), FrameHeaderLen+len(f.Payload))
+		}
+		got, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("ReadFrame(%v): %v", f.Op, err)
+		}
+		if got.Version != f.Version || got.Op != f.Op || got.Flags != f.Flags || got.ID != f.ID || !bytes.Equal(got.Payload, f.Payload) {
+			t.Errorf("round trip of %+v gave %+v", f, got)
+		}
+	}
+}
+
+// ReadFrame must not care how the frame is split across reads.
+func TestReadFrameOneByteAtATime(t *testing.T) {
+	var buf bytes.Buffer
+	want := &Frame{Version: FrameVersion, Op: OpTasks, Flags: FlagReply, ID: 3, Payload: []byte("1 shell RUNNING")}
+	if err := WriteFrame(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFrame(iotest.OneByteReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Payload) != string(want.Payload) {
+		t.Errorf("payload %q, want %q", got.Payload, want.Payload)
+	}
+}
+
+func TestReadFrameNotFramed(t *testing.T) {
+	for _, in := range []string{
+		"MICA-SUCCESS\n\x00\x00\x00\x00",
+		FrameMagic + "\x09\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00",
+		FrameMagic + "\x00\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00",
+	} {
+		if _, err := ReadFrame(bytes.NewBufferString(in)); !errors.Is(err, ErrNotFramed) {
+			t.Errorf("ReadFrame(%q) = %v, want ErrNotFramed", in, err)
+		}
+	}
+}
+
+func TestReadFrameCut(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, &Frame{Version: FrameVersion, Op: OpStatus, ID: 1, Payload: []byte("zephyr01 3 RUNNING")}); err != nil {
+		t.Fatal(err)
+	}
+	cut := buf.Bytes()[:buf.Len()-4]
+	if _, err := ReadFrame(bytes.NewReader(cut)); !errors.Is(err, ErrMalformedReply) {
+		t.Errorf("cut payload: %v, want ErrMalformedReply", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(cut[:FrameHeaderLen-1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("cut header: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestWriteFrameTooLarge(t *testing.T) {
+	f := &Frame{Version: FrameVersion, Op: OpRestore, Payload: make([]byte, MaxFramePayload+1)}
+	if err := WriteFrame(io.Discard, f); err == nil {
+		t.Error("WriteFrame accepted an oversized payload")
+	}
+}
+
+func TestParseOpcode(t *testing.T) {
+	for op := range opcodeNames {
+		got, ok := ParseOpcode(op.String())
+		if !ok || got != op {
+			t.Errorf("ParseOpcode(%q) = %v, %v", op.String(), got, ok)
+		}
+	}
+	if _, ok := ParseOpcode("reboot"); ok {
+		t.Error("ParseOpcode accepted an unknown command")
+	}
+}
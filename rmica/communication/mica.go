@@ -6,16 +6,16 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"rmica/defs"
 
+	securejoin "github.com/cyphar/filepath-securejoin"
 	"gopkg.in/ini.v1"
 )
 
-var SocketPath = defs.DefaultMicaSocket
-
 type CreateMsg struct {
 	CPU     uint32
 	Name    [32]byte
@@ -183,6 +183,113 @@ func ParseConfig(configFile string) (*CreateMsg, error) {
 	return msg, nil
 }
 
+// ParseAnnotations builds the CreateMsg from the org.openeuler.mica.*
+// annotations of an OCI spec. The client is named after defaultName unless
+// client.name is set. A firmware path found inside rootfs is handed to micad
+// as the host path of that file.
+func ParseAnnotations(annotations map[string]string, defaultName, rootfs string) (*CreateMsg, error) {
+	msg := &CreateMsg{}
+
+	name := annotations[defs.MicaAnnoClientName]
+	if name == "" {
+		name = defaultName
+	}
+	if err := putCString(msg.Name[:], name, defs.MicaAnnoClientName); err != nil {
+		return nil, err
+	}
+
+	cpuStr, ok := annotations[defs.MicaAnnoClientCPU]
+	if !ok {
+		return nil, fmt.Errorf("annotation %s is required", defs.MicaAnnoClientCPU)
+	}
+	cpu, err := strconv.ParseUint(cpuStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %v", defs.MicaAnnoClientCPU, cpuStr, err)
+	}
+	msg.CPU = uint32(cpu)
+
+	firmware := annotations[defs.MicaAnnoClientFirmware]
+	if firmware == "" {
+		return nil, fmt.Errorf("annotation %s is required", defs.MicaAnnoClientFirmware)
+	}
+	if err := putCString(msg.Path[:], resolveFirmware(firmware, rootfs), defs.MicaAnnoClientFirmware); err != nil {
+		return nil, err
+	}
+
+	if err := putCString(msg.Ped[:], annotations[defs.MicaAnnoPedestal], defs.MicaAnnoPedestal); err != nil {
+		return nil, err
+	}
+	if err := putCString(msg.PedCfg[:], annotations[defs.MicaAnnoPedestalConf], defs.MicaAnnoPedestalConf); err != nil {
+		return nil, err
+	}
+
+	if debug, ok := annotations[defs.MicaAnnoDebug]; ok {
+		msg.Debug, err = strconv.ParseBool(debug)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q: %v", defs.MicaAnnoDebug, debug, err)
+		}
+	}
+
+	return msg, nil
+}
+
+// resolveFirmware prefers the copy of firmware shipped in the bundle rootfs.
+func resolveFirmware(firmware, rootfs string) string {
+	if rootfs == "" {
+		return firmware
+	}
+	inRootfs, err := securejoin.SecureJoin(rootfs, firmware)
+	if err == nil && fileExists(inRootfs) {
+		return inRootfs
+	}
+	return firmware
+}
+
+// putCString copies s into the fixed size, NUL terminated field dst.
+func putCString(dst []byte, s, field string) error {
+	if len(s) >= len(dst) {
+		return fmt.Errorf("%s %q is too long, micad accepts at most %d bytes", field, s, len(dst)-1)
+	}
+	copy(dst, s)
+	return nil
+}
+
+// ClientName returns the client name carried by the message.
+func (m *CreateMsg) ClientName() string {
+	return cString(m.Name[:])
+}
+
+// CreateMsgSize is the length of a packed CreateMsg.
+const CreateMsgSize = 4 + 32 + 128 + 32 + 128 + 1
+
+// UnpackCreateMsg is the inverse of Pack, as micad reads the message.
+func UnpackCreateMsg(data []byte) (*CreateMsg, error) {
+	if len(data) != CreateMsgSize {
+		return nil, fmt.Errorf("create message has %d bytes, want %d", len(data), CreateMsgSize)
+	}
+	msg := &CreateMsg{CPU: binary.LittleEndian.Uint32(data)}
+	off := 4
+	off += copy(msg.Name[:], data[off:])
+	off += copy(msg.Path[:], data[off:])
+	off += copy(msg.Ped[:], data[off:])
+	off += copy(msg.PedCfg[:], data[off:])
+	msg.Debug = data[off] != 0
+	return msg, nil
+}
+
+// Firmware, Pedestal and PedestalConf return the string fields of the message.
+func (m *CreateMsg) Firmware() string {
+	return cString(m.Path[:])
+}
+
+func (m *CreateMsg) Pedestal() string {
+	return cString(m.Ped[:])
+}
+
+func (m *CreateMsg) PedestalConf() string {
+	return cString(m.PedCfg[:])
+}
+
 // TODO: 重复逻辑 configFile应该考虑缺省的embedded content
 func SendCreateMsg(configFile string) error {
 	micaConfig := configFile
@@ -193,7 +300,7 @@ func SendCreateMsg(configFile string) error {
 		}
 	}
 
-		target := filepath.Join(SocketPath, defs.MicaSocketName)
+		target := filepath.Join(DefaultSocketDir(), defs.MicaSocketName)
 		if !fileExists(target) {
 		return fmt.Errorf("error occurred! Please check if %s is running", target)
 	}
@@ -205,7 +312,7 @@ func SendCreateMsg(configFile string) error {
 
 	fmt.Printf("Creating %s...\n", strings.TrimRight(string(msg.Name[:]), "\x00"))
 
-	socket, err := NewSocket(filepath.Join(SocketPath, defs.MicaSocketName))
+	socket, err := NewSocket(target)
 	if err != nil {
 		return err
 	}
@@ -230,7 +337,7 @@ func SendCreateMsg(configFile string) error {
 }
 
 func SendCtrlMsg(command, client string) error {
-	ctrlSocket := filepath.Join(SocketPath, client+".socket")
+	ctrlSocket := filepath.Join(DefaultSocketDir(), client+".socket")
 	if !fileExists(ctrlSocket) {
 		return fmt.Errorf("cannot find %s. Please run 'mica create <config>' to create it", client)
 	}
@@ -260,23 +367,24 @@ func SendCtrlMsg(command, client string) error {
 }
 
 func QueryStatus() error {
-	if !fileExists(filepath.Join(SocketPath, "mica-create.socket")) {
+	socketDir := DefaultSocketDir()
+	if !fileExists(filepath.Join(socketDir, defs.MicaSocketName)) {
 		return fmt.Errorf("error occurred! Please check if micad is running")
 	}
 
 	fmt.Printf("%-30s%-20s%-20s%s\n", "Name", "Assigned CPU", "State", "Service")
 
-	files, err := os.ReadDir(SocketPath)
+	files, err := os.ReadDir(socketDir)
 	if err != nil {
 		return err
 	}
 
 	for _, file := range files {
-		if file.Name() == "mica-create.socket" || !strings.HasSuffix(file.Name(), ".socket") {
+		if file.Name() == defs.MicaSocketName || !strings.HasSuffix(file.Name(), ".socket") {
 			continue
 		}
 
-		socket, err := NewSocket(filepath.Join(SocketPath, file.Name()))
+		socket, err := NewSocket(filepath.Join(socketDir, file.Name()))
 		if err != nil {
 			continue
 		}
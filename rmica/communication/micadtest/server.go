@@ -0,0 +1,665 @@
+// Package micadtest provides a micad stand-in for tests, in the spirit of
+// net/http/httptest. A Server listens on mica-create.socket and on one
+// <name>.socket per created client below its Dir, keeps a model of the
+// clients and answers in the framed protocol, or in the legacy text
+// protocol of micad when asked so, so that rmica and the shim can be
+// exercised with `go test` alone.
+//
+// Every request can be scripted with Script: delayed, failed, answered
+// only in part or hung up on. Crash and Vanish change a client behind the
+// caller's back, as a misbehaving RTOS or micad would.
+package micadtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rmica/communication"
+	"rmica/defs"
+)
+
+// Operations as they appear in Calls and Script.
+const (
+	OpCreate     = "create"
+	OpStart      = "start"
+	OpStop       = "stop"
+	OpRemove     = "rm"
+	OpStatus     = "status"
+	OpPause      = "pause"
+	OpResume     = "resume"
+	OpTasks      = "ps"
+	OpExec       = "exec"
+	OpExecStatus = "exec-status"
+	OpCheckpoint = "checkpoint"
+	OpRestore    = "restore"
+	OpMigrate    = "migrate"
+)
+
+const (
+	replySuccess = "MICA-SUCCESS\n"
+	replyFailed  = "MICA-FAILED\n"
+)
+
+// Client is the server's model of one micad client.
+type Client struct {
+	Name         string
+	CPU          uint32
+	State        communication.ClientState
+	Service      string
+	Firmware     string
+	Pedestal     string
+	PedestalConf string
+	Debug        bool
+	// Tasks is what ps reports while the client runs, tasks started by
+	// exec are added to it.
+	Tasks []communication.Task
+	// Execs are the tasks started by exec, by task id.
+	Execs map[uint32]*Exec
+	// Image is what checkpoint exports, restore replaces it. A nil
+	// Image exports the name and cpu of the client.
+	Image []byte
+}
+
+// Exec is the server's model of a task started by exec. It runs until
+// ExitTask ends it.
+type Exec struct {
+	Task     communication.ExecTask
+	Exited   bool
+	ExitCode int
+}
+
+// Behavior scripts the answer to one request. The zero Behavior answers
+// like micad would.
+type Behavior struct {
+	// Delay holds the answer back, longer than the caller's timeout
+	// makes it see communication.ErrTimeout.
+	Delay time.Duration
+	// Fail answers MICA-FAILED with Fail as diagnostic, the model is
+	// left unchanged.
+	Fail string
+	// Partial is written as the whole answer, without any verdict.
+	Partial string
+	// Hangup closes the connection without answering.
+	Hangup bool
+}
+
+// Server is a fake micad serving the sockets below Dir.
+type Server struct {
+	Dir string
+	// Legacy makes the server behave like a micad that predates the
+	// framed protocol. Set it before the first request.
+	Legacy bool
+
+	mu        sync.Mutex
+	clients   map[string]*Client
+	listeners map[string]net.Listener
+	scripts   map[string][]Behavior
+	calls     []string
+	conns     map[net.Conn]struct{}
+	closed    bool
+	// done is closed by Close, it cuts scripted delays short
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewServer starts a server on dir/mica-create.socket, dir is created
+// if needed.
+func NewServer(dir string) (*Server, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Dir:       dir,
+		clients:   map[string]*Client{},
+		listeners: map[string]net.Listener{},
+		scripts:   map[string][]Behavior{},
+		conns:     map[net.Conn]struct{}{},
+		done:      make(chan struct{}),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.listen(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SocketClient returns a communication client talking to s.
+func (s *Server) SocketClient() *communication.SocketClient {
+	return communication.NewSocketClient(s.Dir)
+}
+
+// Close stops serving and removes every socket of s. Requests in flight
+// are hung up on.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	var errs []error
+	for name, l := range s.listeners {
+		errs = append(errs, l.Close())
+		delete(s.listeners, name)
+	}
+	// handlers may be blocked reading from a caller that sends nothing
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Script queues behaviors for op on client name, one per request. An empty
+// name matches requests for any client; requests without a scripted
+// behavior left are answered normally.
+func (s *Server) Script(op, name string, behaviors ...Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := scriptKey(op, name)
+	s.scripts[key] = append(s.scripts[key], behaviors...)
+}
+
+// Calls lists the requests served so far as "<op> <name>".
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// Client returns a copy of the model of client name.
+func (s *Server) Client(name string) (Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[name]
+	if !ok {
+		return Client{}, false
+	}
+	return *c, true
+}
+
+// Clients returns copies of all clients, sorted by name.
+func (s *Server) Clients() []Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SetState forces client name into state.
+func (s *Server) SetState(name string, state communication.ClientState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[name]
+	if !ok {
+		return fmt.Errorf("no client %s", name)
+	}
+	c.State = state
+	return nil
+}
+
+// SetService sets the services status lists for client name, e.g.
+// "tty(/dev/pts/3)".
+func (s *Server) SetService(name, service string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[name]
+	if !ok {
+		return fmt.Errorf("no client %s", name)
+	}
+	c.Service = service
+	return nil
+}
+
+// SetTasks sets what ps reports for client name.
+func (s *Server) SetTasks(name string, tasks ...communication.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[name]
+	if !ok {
+		return fmt.Errorf("no client %s", name)
+	}
+	c.Tasks = append([]communication.Task(nil), tasks...)
+	return nil
+}
+
+// ExitTask ends the task id started by exec on client name with code.
+func (s *Server) ExitTask(name string, id uint32, code int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[name]
+	if !ok {
+		return fmt.Errorf("no client %s", name)
+	}
+	e, ok := c.Execs[id]
+	if !ok {
+		return fmt.Errorf("no task %d on client %s", id, name)
+	}
+	e.Exited, e.ExitCode = true, code
+	for i, t := range c.Tasks {
+		if t.ID == id {
+			c.Tasks = append(c.Tasks[:i], c.Tasks[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Crash marks client name as crashed, as micad reports a dead RTOS.
+func (s *Server) Crash(name string) error {
+	return s.SetState(name, communication.ClientCrashed)
+}
+
+// Vanish drops client name together with its socket without a request,
+// as if micad lost track of it.
+func (s *Server) Vanish(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[name]; !ok {
+		return fmt.Errorf("no client %s", name)
+	}
+	s.remove(name)
+	return nil
+}
+
+// listen must be called with s.mu held. name "" is mica-create.socket.
+func (s *Server) listen(name string) error {
+	path := s.socketPath(name)
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	s.listeners[name] = l
+	s.wg.Add(1)
+	go s.serve(l, name)
+	return nil
+}
+
+// remove must be called with s.mu held.
+func (s *Server) remove(name string) {
+	delete(s.clients, name)
+	if l, ok := s.listeners[name]; ok {
+		// closing a unix listener unlinks its socket
+		l.Close()
+		delete(s.listeners, name)
+	}
+}
+
+func (s *Server) socketPath(name string) string {
+	if name == "" {
+		return filepath.Join(s.Dir, defs.MicaSocketName)
+	}
+	return filepath.Join(s.Dir, name+".socket")
+}
+
+func (s *Server) serve(l net.Listener, name string) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handle(conn, name)
+		}()
+	}
+}
+
+// handle answers one request on the socket of client name, "" being
+// mica-create.socket, in whichever protocol the caller speaks.
+func (s *Server) handle(conn net.Conn, name string) {
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return
+	}
+	data := buf[:n]
+
+	s.mu.Lock()
+	legacy := s.Legacy
+	s.mu.Unlock()
+
+	c := &conversation{conn: conn}
+	if !legacy && bytes.HasPrefix(data, []byte(communication.FrameMagic)) {
+		f, err := communication.ReadFrame(io.MultiReader(bytes.NewReader(data), conn))
+		if err != nil {
+			return
+		}
+		c.frame = f
+		switch {
+		case f.Op == communication.OpHello:
+			c.reply(strings.Join(servedOps, " "), false)
+		case name == "" && f.Op == communication.OpCreate:
+			s.create(c, f.Payload)
+		case name != "" && f.Op != communication.OpCreate:
+			s.control(c, name, f.Op.String(), f.Payload)
+		default:
+			c.reply(fmt.Sprintf("%s is not served on this socket", f.Op), true)
+		}
+		return
+	}
+
+	if name == "" {
+		s.create(c, data)
+	} else {
+		s.control(c, name, strings.TrimSpace(string(data)), nil)
+	}
+}
+
+// legacyOps are the commands a micad without the framed protocol knows.
+var legacyOps = map[string]bool{
+	OpStart: true, OpStop: true, OpRemove: true, OpStatus: true, OpPause: true, OpResume: true,
+}
+
+// servedOps is what the server announces in the handshake.
+var servedOps = []string{
+	OpCreate, OpStart, OpStop, OpRemove, OpStatus, OpPause, OpResume,
+	OpTasks, OpExec, OpExecStatus, OpCheckpoint, OpRestore, OpMigrate,
+}
+
+func (s *Server) create(c *conversation, data []byte) {
+	msg, err := communication.UnpackCreateMsg(data)
+	if err != nil {
+		c.reply(err.Error(), true)
+		return
+	}
+	name := msg.ClientName()
+	if !s.script(c, OpCreate, name) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if _, ok := s.clients[name]; ok {
+		c.reply("client "+name+" already exists", true)
+		return
+	}
+	if err := s.listen(name); err != nil {
+		c.reply(err.Error(), true)
+		return
+	}
+	s.clients[name] = &Client{
+		Name:         name,
+		CPU:          msg.CPU,
+		State:        communication.ClientOffline,
+		Firmware:     msg.Firmware(),
+		Pedestal:     msg.Pedestal(),
+		PedestalConf: msg.PedestalConf(),
+		Debug:        msg.Debug,
+	}
+	c.reply("", false)
+}
+
+// control answers op for client name, payload is what a framed request
+// carried besides the opcode.
+func (s *Server) control(c *conversation, name, op string, payload []byte) {
+	if !s.script(c, op, name) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[name]
+	if !ok {
+		c.reply("no client "+name, true)
+		return
+	}
+	if c.frame == nil && !legacyOps[op] {
+		c.reply(fmt.Sprintf("unknown command %q", op), true)
+		return
+	}
+	out, err := s.apply(client, op, payload)
+	if err != nil {
+		c.reply(err.Error(), true)
+		return
+	}
+	c.reply(out, false)
+}
+
+// apply runs op on c and returns the output micad prints before its verdict.
+// It must be called with s.mu held.
+func (s *Server) apply(c *Client, op string, payload []byte) (string, error) {
+	from := c.State
+	switch op {
+	case OpStart:
+		if from == communication.ClientRunning || from == communication.ClientSuspended {
+			return "", fmt.Errorf("client %s is already %s", c.Name, from)
+		}
+		c.State = communication.ClientRunning
+	case OpStop:
+		if from == communication.ClientOffline {
+			return "", fmt.Errorf("client %s is not running", c.Name)
+		}
+		c.State = communication.ClientOffline
+	case OpPause:
+		if from != communication.ClientRunning {
+			return "", fmt.Errorf("client %s is %s, not running", c.Name, from)
+		}
+		c.State = communication.ClientSuspended
+	case OpResume:
+		if from != communication.ClientSuspended {
+			return "", fmt.Errorf("client %s is %s, not suspended", c.Name, from)
+		}
+		c.State = communication.ClientRunning
+	case OpRemove:
+		if from == communication.ClientRunning || from == communication.ClientSuspended {
+			return "", fmt.Errorf("client %s is still %s", c.Name, from)
+		}
+		s.remove(c.Name)
+	case OpStatus:
+		return fmt.Sprintf("%-16s%-8s%-12s%s\n%-16s%-8d%-12s%s\n",
+			"Name", "CPU", "State", "Service",
+			c.Name, c.CPU, strings.ToUpper(string(c.State)), c.Service), nil
+	case OpTasks:
+		if from == communication.ClientOffline {
+			return "", fmt.Errorf("client %s is not running", c.Name)
+		}
+		var out strings.Builder
+		fmt.Fprintf(&out, "%-8s%-24s%s\n", "ID", "Name", "State")
+		for _, t := range c.Tasks {
+			fmt.Fprintf(&out, "%-8d%-24s%s\n", t.ID, t.Name, strings.ToUpper(t.State))
+		}
+		return out.String(), nil
+	case OpExec:
+		if from != communication.ClientRunning {
+			return "", fmt.Errorf("client %s is %s, not running", c.Name, from)
+		}
+		var task communication.ExecTask
+		if err := json.Unmarshal(payload, &task); err != nil {
+			return "", fmt.Errorf("bad exec task: %v", err)
+		}
+		if len(task.Args) == 0 {
+			return "", errors.New("exec task without args")
+		}
+		var id uint32 = 1
+		for _, t := range c.Tasks {
+			id = max(id, t.ID+1)
+		}
+		if c.Execs == nil {
+			c.Execs = map[uint32]*Exec{}
+		}
+		c.Execs[id] = &Exec{Task: task}
+		c.Tasks = append(c.Tasks, communication.Task{ID: id, Name: path.Base(task.Args[0]), State: "running"})
+		return strconv.FormatUint(uint64(id), 10), nil
+	case OpCheckpoint:
+		if from != communication.ClientRunning && from != communication.ClientSuspended {
+			return "", fmt.Errorf("client %s is %s, nothing to export", c.Name, from)
+		}
+		image := c.Image
+		if image == nil {
+			image = []byte(fmt.Sprintf("%s@%d", c.Name, c.CPU))
+		}
+		return base64.StdEncoding.EncodeToString(image), nil
+	case OpRestore:
+		if from != communication.ClientOffline {
+			return "", fmt.Errorf("client %s is already %s", c.Name, from)
+		}
+		c.Image = append([]byte(nil), payload...)
+		c.State = communication.ClientRunning
+	case OpMigrate:
+		cpu, err := strconv.ParseUint(string(payload), 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("bad cpu %q", payload)
+		}
+		for _, other := range s.clients {
+			if other != c && other.CPU == uint32(cpu) {
+				return "", fmt.Errorf("cpu %d is used by client %s", cpu, other.Name)
+			}
+		}
+		c.CPU = uint32(cpu)
+	case OpExecStatus:
+		id, err := strconv.ParseUint(string(payload), 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("bad task id %q", payload)
+		}
+		e, ok := c.Execs[uint32(id)]
+		if !ok {
+			return "", fmt.Errorf("no task %d on client %s", id, c.Name)
+		}
+		if !e.Exited {
+			return "running", nil
+		}
+		return fmt.Sprintf("exited %d", e.ExitCode), nil
+	default:
+		return "", fmt.Errorf("unknown command %q", op)
+	}
+	return "", nil
+}
+
+// script records the request and plays the next behavior scripted for it.
+// It reports whether the request should still be answered normally.
+func (s *Server) script(c *conversation, op, name string) bool {
+	s.mu.Lock()
+	s.calls = append(s.calls, op+" "+name)
+	b, ok := s.next(scriptKey(op, name))
+	if !ok {
+		b, ok = s.next(scriptKey(op, ""))
+	}
+	s.mu.Unlock()
+	if !ok {
+		return true
+	}
+
+	if b.Delay > 0 {
+		select {
+		case <-time.After(b.Delay):
+		case <-s.done:
+			return false
+		}
+	}
+	switch {
+	case b.Hangup:
+		return false
+	case b.Partial != "":
+		c.partial(b.Partial)
+		return false
+	case b.Fail != "":
+		c.reply(b.Fail, true)
+		return false
+	}
+	return true
+}
+
+// next pops the first behavior queued under key, s.mu must be held.
+func (s *Server) next(key string) (Behavior, bool) {
+	queue := s.scripts[key]
+	if len(queue) == 0 {
+		return Behavior{}, false
+	}
+	s.scripts[key] = queue[1:]
+	return queue[0], true
+}
+
+func scriptKey(op, name string) string {
+	return op + " " + name
+}
+
+// conversation answers a request in the protocol it came in.
+type conversation struct {
+	conn net.Conn
+	// frame is the request, nil for the legacy protocol
+	frame *communication.Frame
+}
+
+func (c *conversation) reply(out string, failed bool) {
+	if c.frame == nil {
+		verdict := replySuccess
+		if failed {
+			verdict = replyFailed
+		}
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		io.WriteString(c.conn, out+verdict)
+		return
+	}
+	f := &communication.Frame{
+		Version: communication.FrameVersion,
+		Op:      c.frame.Op,
+		Flags:   communication.FlagReply,
+		ID:      c.frame.ID,
+		Payload: []byte(out),
+	}
+	if failed {
+		f.Flags |= communication.FlagFailed
+	}
+	communication.WriteFrame(c.conn, f)
+}
+
+// partial writes p as an answer cut short: the bare text for the legacy
+// protocol, a frame announcing more payload than p for the framed one.
+func (c *conversation) partial(p string) {
+	if c.frame == nil {
+		io.WriteString(c.conn, p)
+		return
+	}
+	var buf bytes.Buffer
+	communication.WriteFrame(&buf, &communication.Frame{
+		Version: communication.FrameVersion,
+		Op:      c.frame.Op,
+		Flags:   communication.FlagReply,
+		ID:      c.frame.ID,
+		Payload: []byte(p + "\n" + replySuccess),
+	})
+	c.conn.Write(buf.Bytes()[:communication.FrameHeaderLen+len(p)])
+}
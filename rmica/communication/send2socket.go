@@ -2,71 +2,173 @@ package communication
 
 // communication with MICAD
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
 	"syscall"
+	"time"
 
-	"rmica/defs"
 	"rmica/logger"
 )
 
-func Send2micaCmd(cmd string, args ...string) string {
-	return Send2mica(strings.Join(append([]string{cmd}, args...), " "))
+const (
+	replySuccess = "MICA-SUCCESS"
+	replyFailed  = "MICA-FAILED"
+)
+
+// send2socket writes data to the micad socket at path and reads until micad
+// gives its verdict. On success it returns the text micad sent before
+// MICA-SUCCESS; MICA-FAILED comes back as a *FailedError.
+func send2socket(ctx context.Context, data []byte, path string) (string, error) {
+	conn, err := dialSocket(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	logger.Debugf("trying to write byte sequence: %s \n [%x]", data, data)
+	if _, err := conn.Write(data); err != nil {
+		return "", ctxErr(ctx, fmt.Errorf("failed to write to socket: %w", classifyNetErr(err)))
+	}
+
+	out, err := readReply(conn)
+	return out, ctxErr(ctx, err)
 }
 
-func Send2mica(data string) string {
-	logger.Debugf("sending data [%s] to [%s]", data, defs.DefaultMicaSocket)
-	res, err := send2socket(data, defs.DefaultMicaSocket)
+// sendFrame sends req over the framed protocol and returns the reply frame.
+// A reply with FlagFailed comes back as a *FailedError.
+func sendFrame(ctx context.Context, req *Frame, path string) (*Frame, error) {
+	conn, err := dialSocket(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	logger.Debugf("trying to write %s frame #%d to %s", req.Op, req.ID, path)
+	if err := WriteFrame(conn, req); err != nil {
+		return nil, ctxErr(ctx, fmt.Errorf("failed to write to socket: %w", classifyNetErr(err)))
+	}
+
+	reply, err := ReadFrame(conn)
 	if err != nil {
-		logger.Errorf("failed to send data to micad %v", err)
-		logger.Fprintf("failed to send data to micad %v", err)
-		return ""
+		if ctx.Err() != nil {
+			return nil, ctxErr(ctx, fmt.Errorf("failed to read response: %w", classifyNetErr(err)))
+		}
+		if errors.Is(err, ErrNotFramed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedReply, err)
+		}
+		if errors.Is(err, ErrMalformedReply) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read response: %w", classifyNetErr(err))
+	}
+	if reply.Flags&FlagReply == 0 || reply.ID != req.ID || reply.Op != req.Op {
+		return nil, fmt.Errorf("%w: got %s frame #%d for %s request #%d",
+			ErrMalformedReply, reply.Op, reply.ID, req.Op, req.ID)
 	}
-	logger.Infof("received response from micad: %s", res)
-	return res
+	if reply.Flags&FlagFailed != 0 {
+		return nil, &FailedError{Diag: strings.TrimSpace(string(reply.Payload))}
+	}
+	return reply, nil
 }
 
-func send2socket(data, path string) (string, error) {
+// dialSocket connects to the micad socket at path. The connection expires
+// with the deadline of ctx and is unblocked as soon as ctx is cancelled.
+func dialSocket(ctx context.Context, path string) (net.Conn, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
 		logger.Fprintf("failed to stat socket file: %v", err)
 		logger.Debugf("failed to stat socket file: %v", err)
-		return "", fmt.Errorf("failed to stat socket file: %w", err)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s does not exist", ErrConnRefused, path)
+		}
+		return nil, fmt.Errorf("failed to stat socket file: %w", err)
 	}
 
 	if fileInfo.Mode()&os.ModeSocket == 0 {
 		logger.Fprintf("%s is not a socket file", path)
 		logger.Debugf("%s is not a socket file", path)
-		return "", fmt.Errorf("%s is not a socket file", path)
+		return nil, fmt.Errorf("%s is not a socket file", path)
 	}
 
-	conn, err := net.Dial("unix", path)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
 	if err != nil {
 		logger.Fprintf("failed to connect to socket: %v", err)
 		logger.Debugf("failed to connect to socket: %v", err)
-		return "", fmt.Errorf("failed to connect to socket: %w", err)
+		return nil, ctxErr(ctx, classifyNetErr(err))
 	}
-	defer conn.Close()
 
-	logger.Debugf("trying to write byte sequence: %s \n [%x]", data, []byte(data))
-	_, err = conn.Write([]byte(data))
-	if err != nil {
-		return "", fmt.Errorf("failed to write to socket: %w", err)
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
+	stop := context.AfterFunc(ctx, func() {
+		// wake up a pending read or write
+		conn.SetDeadline(time.Now())
+	})
+	return &ctxConn{Conn: conn, stop: stop}, nil
+}
+
+// ctxConn stops watching its context once closed.
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
 
+// readReply accumulates the reply until a verdict shows up,
+// micad may split long output over several writes.
+func readReply(r io.Reader) (string, error) {
 	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
-	if err != nil {
-		if err != syscall.EAGAIN {
-			return "", fmt.Errorf("failed to read response: %w", err)
+	var response strings.Builder
+	for {
+		n, err := r.Read(buf)
+		response.Write(buf[:n])
+		respStr := response.String()
+
+		if i := strings.Index(respStr, replyFailed); i >= 0 {
+			return "", &FailedError{Diag: strings.TrimSpace(respStr[:i])}
+		}
+		if i := strings.Index(respStr, replySuccess); i >= 0 {
+			return strings.TrimSpace(respStr[:i]), nil
 		}
-		// EAGAIN means no data available, which is fine
-	}
 
-	response := string(buf[:n])
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", fmt.Errorf("%w: connection closed without verdict after %q", ErrMalformedReply, respStr)
+			}
+			return "", fmt.Errorf("failed to read response: %w", classifyNetErr(err))
+		}
+	}
+}
 
-	return response, nil
+// ctxErr reports err as the cancellation it is when ctx was cancelled
+// rather than let it pass for a micad timeout.
+func ctxErr(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", context.Canceled, err)
 }
 
+func classifyNetErr(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("%w: %v", ErrConnRefused, err)
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+package communication_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/communication/micadtest"
+	"rmica/defs"
+)
+
+func newServer(t *testing.T, legacy bool) *micadtest.Server {
+	t.Helper()
+	srv, err := micadtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Legacy = legacy
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func createMsg(name string, cpu uint32) *communication.CreateMsg {
+	msg := &communication.CreateMsg{CPU: cpu}
+	copy(msg.Name[:], name)
+	copy(msg.Path[:], "/lib/firmware/"+name+".elf")
+	return msg
+}
+
+func TestSocketClientLifecycle(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		legacy   bool
+		protocol communication.Protocol
+	}{
+		{"legacy", true, communication.ProtocolLegacy},
+		{"framed", false, communication.ProtocolFramed},
+		{"auto", false, communication.ProtocolAuto},
+		{"auto against a legacy micad", true, communication.ProtocolAuto},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newServer(t, tc.legacy)
+			micad := srv.SocketClient()
+			micad.Protocol = tc.protocol
+			ctx := context.Background()
+
+			if _, err := micad.Create(ctx, createMsg("zephyr01", 3)); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			c, ok := srv.Client("zephyr01")
+			if !ok || c.CPU != 3 || c.Firmware != "/lib/firmware/zephyr01.elf" {
+				t.Fatalf("micad created %+v", c)
+			}
+			st, err := micad.Status(ctx, "zephyr01")
+			if err != nil {
+				t.Fatalf("status: %v", err)
+			}
+			if st.State != communication.ClientOffline || st.CPU != 3 {
+				t.Errorf("created client is %+v", st)
+			}
+
+			for _, step := range []struct {
+				op   func(context.Context, string) (*communication.Reply, error)
+				want communication.ClientState
+			}{
+				{micad.Start, communication.ClientRunning},
+				{micad.Pause, communication.ClientSuspended},
+				{micad.Resume, communication.ClientRunning},
+				{micad.Stop, communication.ClientOffline},
+			} {
+				if _, err := step.op(ctx, "zephyr01"); err != nil {
+					t.Fatalf("-> %s: %v", step.want, err)
+				}
+				if st, err := micad.Status(ctx, "zephyr01"); err != nil || st.State != step.want {
+					t.Fatalf("status after -> %s: %+v, %v", step.want, st, err)
+				}
+			}
+
+			if _, err := micad.Remove(ctx, "zephyr01"); err != nil {
+				t.Fatalf("rm: %v", err)
+			}
+			if _, err := micad.Status(ctx, "zephyr01"); !errors.Is(err, communication.ErrConnRefused) {
+				t.Errorf("status of a removed client: %v, want ErrConnRefused", err)
+			}
+		})
+	}
+}
+
+func TestSocketClientCapabilities(t *testing.T) {
+	ctx := context.Background()
+
+	micad := newServer(t, false).SocketClient()
+	micad.Protocol = communication.ProtocolFramed
+	if caps := micad.Capabilities(ctx); !slices.Contains(caps, "ps") || !slices.Contains(caps, "migrate") {
+		t.Errorf("framed micad announced %v", caps)
+	}
+
+	srv := newServer(t, true)
+	micad = srv.SocketClient()
+	micad.Protocol = communication.ProtocolLegacy
+	if caps := micad.Capabilities(ctx); caps != nil {
+		t.Errorf("legacy client reports capabilities %v", caps)
+	}
+	if _, err := micad.Create(ctx, createMsg("zephyr01", 3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := micad.Tasks(ctx, "zephyr01"); !errors.Is(err, communication.ErrNotSupported) {
+		t.Errorf("ps on a legacy micad: %v, want ErrNotSupported", err)
+	}
+	// the legacy protocol never sends a hello frame, which a legacy micad
+	// would take for a CreateMsg
+	for _, call := range srv.Calls() {
+		if call != "create zephyr01" {
+			t.Errorf("legacy client sent %q", call)
+		}
+	}
+}
+
+func TestSocketClientTasks(t *testing.T) {
+	srv := newServer(t, false)
+	micad := srv.SocketClient()
+	micad.Protocol = communication.ProtocolFramed
+	ctx := context.Background()
+
+	if _, err := micad.Create(ctx, createMsg("zephyr01", 3)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := micad.Start(ctx, "zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	want := []communication.Task{{ID: 1, Name: "shell", State: "running"}}
+	if err := srv.SetTasks("zephyr01", want...); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := micad.Tasks(ctx, "zephyr01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tasks, want) {
+		t.Errorf("ps: %+v, want %+v", tasks, want)
+	}
+
+	id, err := micad.Exec(ctx, "zephyr01", &communication.ExecTask{Args: []string{"/bin/sensor"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ExitTask("zephyr01", id, 7); err != nil {
+		t.Fatal(err)
+	}
+	st, err := micad.ExecStatus(ctx, "zephyr01", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Exited || st.ExitCode != 7 {
+		t.Errorf("exec-status: %+v", st)
+	}
+}
+
+func TestSocketClientErrors(t *testing.T) {
+	for _, legacy := range []bool{true, false} {
+		srv := newServer(t, legacy)
+		micad := srv.SocketClient()
+		if !legacy {
+			micad.Protocol = communication.ProtocolFramed
+		}
+		micad.Timeout = 100 * time.Millisecond
+		ctx := context.Background()
+
+		if _, err := micad.Create(ctx, createMsg("zephyr01", 3)); err != nil {
+			t.Fatal(err)
+		}
+
+		srv.Script(micadtest.OpStart, "zephyr01", micadtest.Behavior{Fail: "no such firmware"})
+		_, err := micad.Start(ctx, "zephyr01")
+		var failed *communication.FailedError
+		if !errors.As(err, &failed) || failed.Op != "start" || failed.Client != "zephyr01" || failed.Diag != "no such firmware" {
+			t.Errorf("legacy=%v: failed start: %v", legacy, err)
+		}
+
+		srv.Script(micadtest.OpStart, "zephyr01", micadtest.Behavior{Delay: time.Second})
+		if _, err := micad.Start(ctx, "zephyr01"); !errors.Is(err, communication.ErrTimeout) {
+			t.Errorf("legacy=%v: slow start: %v, want ErrTimeout", legacy, err)
+		}
+
+		srv.Script(micadtest.OpStop, "zephyr01", micadtest.Behavior{Partial: "stopping"})
+		if _, err := micad.Stop(ctx, "zephyr01"); !errors.Is(err, communication.ErrMalformedReply) {
+			t.Errorf("legacy=%v: cut stop: %v, want ErrMalformedReply", legacy, err)
+		}
+
+		if _, err := micad.Start(ctx, "nonexistent"); !errors.Is(err, communication.ErrConnRefused) {
+			t.Errorf("legacy=%v: start of an unknown client: %v, want ErrConnRefused", legacy, err)
+		}
+	}
+}
+
+// Close must not wait for callers that never send their request.
+func TestServerCloseWithIdleConnection(t *testing.T) {
+	srv, err := micadtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", filepath.Join(srv.Dir, defs.MicaSocketName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	closed := make(chan error, 1)
+	go func() { closed <- srv.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hangs on an idle connection")
+	}
+}
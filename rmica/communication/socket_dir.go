@@ -0,0 +1,72 @@
+package communication
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"rmica/defs"
+	"rmica/logger"
+)
+
+// SocketSource is everything that may say where micad listens, in order of
+// precedence: the command line, RMICA_MICA_SOCKET, the directory recorded
+// for an existing container and the bundle annotation.
+type SocketSource struct {
+	// FlagSocket is --mica-socket, the path of mica-create.socket.
+	FlagSocket string
+	// FlagDir is --mica-dir, the directory holding the micad sockets.
+	FlagDir string
+	// Recorded is the directory a container was created against.
+	Recorded string
+	// Annotations of the bundle, may hold defs.MicaAnnoMicadSocket.
+	Annotations map[string]string
+}
+
+// ResolveSocketDir returns the directory holding mica-create.socket and the
+// <name>.socket control sockets. This is the only place deciding where
+// rmica and the shim talk to micad.
+func ResolveSocketDir(src SocketSource) (string, error) {
+	if src.FlagSocket != "" && src.FlagDir != "" {
+		return "", fmt.Errorf("--mica-socket and --mica-dir are mutually exclusive")
+	}
+	switch {
+	case src.FlagSocket != "":
+		return socketDir("--mica-socket", src.FlagSocket)
+	case src.FlagDir != "":
+		return filepath.Abs(src.FlagDir)
+	}
+	if env := os.Getenv(defs.MicaSocketEnv); env != "" {
+		return socketDir(defs.MicaSocketEnv, env)
+	}
+	if src.Recorded != "" {
+		return src.Recorded, nil
+	}
+	if anno := src.Annotations[defs.MicaAnnoMicadSocket]; anno != "" {
+		return socketDir(defs.MicaAnnoMicadSocket, anno)
+	}
+	return defs.DefaultMicaDir, nil
+}
+
+// DefaultSocketDir resolves the socket directory without any command line
+// or bundle, falling back to defs.DefaultMicaDir on a bad environment.
+func DefaultSocketDir() string {
+	dir, err := ResolveSocketDir(SocketSource{})
+	if err != nil {
+		logger.Warnf("%v, using %s", err, defs.DefaultMicaDir)
+		return defs.DefaultMicaDir
+	}
+	return dir
+}
+
+// socketDir checks that path names mica-create.socket and returns its directory.
+func socketDir(from, path string) (string, error) {
+	if filepath.Base(path) != defs.MicaSocketName {
+		return "", fmt.Errorf("%s: %s does not name %s", from, path, defs.MicaSocketName)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", from, err)
+	}
+	return filepath.Dir(abs), nil
+}
@@ -0,0 +1,62 @@
+package communication
+
+import (
+	"context"
+	"time"
+
+	"rmica/logger"
+)
+
+// DefaultTimeout bounds an operation without an entry in OpTimeouts.
+const DefaultTimeout = 5 * time.Second
+
+// OpTimeouts are the defaults used while SocketClient.Timeout is 0. micad
+// loads the firmware on create and boots or halts the client on start and
+// stop, status only reads its own bookkeeping.
+var OpTimeouts = map[Opcode]time.Duration{
+	OpHello:      time.Second,
+	OpCreate:     30 * time.Second,
+	OpStart:      30 * time.Second,
+	OpStop:       15 * time.Second,
+	OpRemove:     15 * time.Second,
+	OpStatus:     2 * time.Second,
+	OpPause:      5 * time.Second,
+	OpResume:     5 * time.Second,
+	OpTasks:      2 * time.Second,
+	OpExec:       10 * time.Second,
+	OpExecStatus: 2 * time.Second,
+	OpCheckpoint: 30 * time.Second,
+	OpRestore:    30 * time.Second,
+	OpMigrate:    30 * time.Second,
+}
+
+const (
+	// statusAttempts is how often an idempotent request is tried.
+	statusAttempts = 4
+	// first and longest wait between two attempts
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = time.Second
+	// pingTimeout bounds the probe whether micad is up at all.
+	pingTimeout = 100 * time.Millisecond
+)
+
+// retry calls fn up to attempts times, doubling the wait in between, for as
+// long as retryable says the error may go away and ctx is not done.
+func retry(ctx context.Context, attempts int, retryable func(error) bool, fn func() error) error {
+	backoff := retryBackoff
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || i >= attempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		logger.Debugf("micad not ready (attempt %d/%d), retrying in %s: %v", i, attempts, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
@@ -14,12 +14,23 @@ but does not actually handling any containers following the OCI specification.
 	Root = DefaultRootDir
 	// DefaultMicaSocket = "/var/run/micad.sock"
 
-	DefaultMicaSocket = "/tmp/mica/mica-create.socket"
+	// micad keeps mica-create.socket and one <name>.socket per client here,
+	// override with --mica-socket, --mica-dir or RMICA_MICA_SOCKET
+	DefaultMicaDir    = "/tmp/mica"
+	DefaultMicaSocket = DefaultMicaDir + "/" + MicaSocketName
+	MicaSocketEnv     = "RMICA_MICA_SOCKET"
+	// legacy (default), framed or auto, override with --mica-protocol
+	MicaProtocolEnv   = "RMICA_MICA_PROTOCOL"
 	SysVLogPath = "/var/log/rmica" // permission check
 	DefaultLogFile = "/var/tmp/rmica.log"
 
 	StateFilename    = "state.json"
 	ExecFifoFilename = "exec.fifo"
+	// ExecsDirname holds one <task id>.json per task started by `rmica exec`
+	ExecsDirname     = "execs"
+	// files of a `rmica checkpoint` image directory
+	CheckpointFilename  = "mica-checkpoint.json"
+	ClientStateFilename = "client-state.img"
 
 	ContainerDirPerm = 0o700
 
@@ -29,4 +40,18 @@ but does not actually handling any containers following the OCI specification.
 	MicaSocketName 		 = "mica-create.socket"
 	// prefix of annotaion fields belonging to mica
 	MicaAnnotationPrefix = "org.openeuler.mica."
+
+	// annotations turned into the micad CreateMsg
+	MicaAnnoClientName     = MicaAnnotationPrefix + "client.name"
+	MicaAnnoClientCPU      = MicaAnnotationPrefix + "client.cpu"
+	MicaAnnoClientFirmware = MicaAnnotationPrefix + "client.firmware"
+	MicaAnnoPedestal       = MicaAnnotationPrefix + "client.pedestal"
+	MicaAnnoPedestalConf   = MicaAnnotationPrefix + "client.pedestal_conf"
+	MicaAnnoDebug          = MicaAnnotationPrefix + "client.debug"
+
+	// host device carrying the client console, instead of the tty micad exposes
+	MicaAnnoClientConsole = MicaAnnotationPrefix + "client.console"
+
+	// path of the mica-create.socket serving this bundle
+	MicaAnnoMicadSocket = MicaAnnotationPrefix + "micad.socket"
 )
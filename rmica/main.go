@@ -48,6 +48,25 @@ func main() {
 			Value: defs.Root,
 			Usage: "root directory for storage of container state",
 		},
+		cli.StringFlag{
+			Name:  "mica-socket",
+			Value: "",
+			Usage: "path of micad's mica-create.socket, the client sockets are looked up next to it (default: $" + defs.MicaSocketEnv + ", the " + defs.MicaAnnoMicadSocket + " annotation or " + defs.DefaultMicaSocket + ")",
+		},
+		cli.StringFlag{
+			Name:  "mica-dir",
+			Value: "",
+			Usage: "directory holding micad's sockets, an alternative to --mica-socket",
+		},
+		cli.DurationFlag{
+			Name:  "mica-timeout",
+			Usage: "give up on a micad operation after this long, 0 uses the default of each operation",
+		},
+		cli.StringFlag{
+			Name:  "mica-protocol",
+			Value: "",
+			Usage: "how to talk to micad: 'legacy', 'framed' or 'auto' to ask micad with a hello frame (default: $" + defs.MicaProtocolEnv + " or legacy)",
+		},
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "enable debug output and flesh the debug file: " + defs.DefaultLogFile,
@@ -78,13 +97,26 @@ func main() {
 		// Required by OCI specifications
 		commands.CreateCommand,
 		commands.StartCommand,
+		commands.KillCommand,
 		commands.DeleteCommand,
 		commands.ListCommand,
 		commands.StateCommand,
 		// Common commands
 		commands.RunCommand,
 		commands.SpecCommand,
+		commands.PsCommand,
+		commands.ExecCommand,
+		commands.PauseCommand,
+		commands.ResumeCommand,
+		commands.CheckpointCommand,
+		commands.RestoreCommand,
+		commands.UpdateCommand,
+		commands.FeaturesCommand,
 		// Extenstions
+		commands.EventsCommand,
+		commands.WaitCommand,
+		commands.ProxyCommand,
+		commands.MonitorCommand,
 	}
 
 
@@ -2,6 +2,8 @@ package mcs
 
 type ClientStats struct {
 	CoreStats CpuStats `json:"core_starts,omitempty"`
+	// Name and State as micad reports them
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Service string `json:"service,omitempty"`
 }
-
-
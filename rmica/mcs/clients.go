@@ -2,6 +2,8 @@ package mcs
 
 type CpuStats struct {
 	MaxCpu uint32 `json:"max_cpu,omitempty"`
+	// Cpu the client is assigned to
+	Cpu uint32 `json:"cpu"`
 }
 
 type ClientConf struct {
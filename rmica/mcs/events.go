@@ -0,0 +1,17 @@
+package mcs
+
+// Event is one line of `rmica events`, shaped like runc's events.
+type Event struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// event types besides the periodic "stats"
+const (
+	EventStats   = "stats"
+	EventRunning = "running"
+	EventPaused  = "paused"
+	EventStopped = "stopped"
+	EventCrashed = "crashed"
+)
@@ -0,0 +1,101 @@
+// Package monitor watches micad clients on behalf of rmica and the shim.
+// An RTOS client has no host process to wait(2) on, so its exit is
+// observed by polling micad for the client status.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"rmica/communication"
+	"rmica/logger"
+)
+
+const (
+	// ExitStatusStopped is reported for a client that went offline on its own.
+	ExitStatusStopped = 0
+	// ExitStatusCrashed is reported for a crashed client, or one micad dropped.
+	ExitStatusCrashed = 1
+
+	// DefaultInterval is how often Wait polls micad.
+	DefaultInterval = time.Second
+)
+
+// Exit records how and when a client stopped.
+type Exit struct {
+	Status   int       `json:"status"`
+	ExitedAt time.Time `json:"exitedAt"`
+}
+
+// NewExit stamps status with the current time.
+func NewExit(status int) *Exit {
+	return &Exit{Status: status, ExitedAt: time.Now().UTC()}
+}
+
+// KilledStatus is the exit status of a client stopped by sig, following
+// the shell convention for processes killed by a signal.
+func KilledStatus(sig syscall.Signal) int {
+	return 128 + int(sig)
+}
+
+// Exited interprets the outcome of a micad status query. exited is true
+// with the exit status when the client is gone; err is only returned for
+// failures that say nothing about the client, such as a micad timeout.
+func Exited(st *communication.ClientStatus, err error) (status int, exited bool, retErr error) {
+	if err != nil {
+		var failed *communication.FailedError
+		if errors.Is(err, communication.ErrConnRefused) || errors.As(err, &failed) {
+			// the client socket is gone or micad disowns the client
+			return ExitStatusCrashed, true, nil
+		}
+		return 0, false, err
+	}
+	switch st.State {
+	case communication.ClientOffline:
+		return ExitStatusStopped, true, nil
+	case communication.ClientCrashed:
+		return ExitStatusCrashed, true, nil
+	}
+	return 0, false, nil
+}
+
+// Watcher waits for micad clients to exit.
+type Watcher struct {
+	micad    communication.MicadClient
+	interval time.Duration
+}
+
+// NewWatcher polls micad every interval, DefaultInterval if interval <= 0.
+func NewWatcher(micad communication.MicadClient, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Watcher{micad: micad, interval: interval}
+}
+
+// Wait blocks until client exits or ctx is done. Transient micad errors
+// are logged and the client is polled again.
+func (w *Watcher) Wait(ctx context.Context, client string) (*Exit, error) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		status, exited, err := Exited(w.micad.Status(ctx, client))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logger.Warnf("[monitor] status of client %s: %v", client, err)
+		} else if exited {
+			logger.Debugf("[monitor] client %s exited with status %d", client, status)
+			return NewExit(status), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package monitor_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/communication/micadtest"
+	"rmica/monitor"
+)
+
+const testInterval = 10 * time.Millisecond
+
+func TestExited(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		st     *communication.ClientStatus
+		err    error
+		status int
+		exited bool
+		fails  bool
+	}{
+		{name: "running", st: &communication.ClientStatus{State: communication.ClientRunning}},
+		{name: "suspended", st: &communication.ClientStatus{State: communication.ClientSuspended}},
+		{name: "offline", st: &communication.ClientStatus{State: communication.ClientOffline}, status: 0, exited: true},
+		{name: "crashed", st: &communication.ClientStatus{State: communication.ClientCrashed}, status: 1, exited: true},
+		{name: "socket gone", err: fmt.Errorf("micad status zephyr01: %w", communication.ErrConnRefused), status: 1, exited: true},
+		{name: "disowned", err: &communication.FailedError{Op: "status", Client: "zephyr01"}, status: 1, exited: true},
+		{name: "timeout", err: fmt.Errorf("micad status zephyr01: %w", communication.ErrTimeout), fails: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, exited, err := monitor.Exited(tc.st, tc.err)
+			if (err != nil) != tc.fails {
+				t.Fatalf("err %v", err)
+			}
+			if status != tc.status || exited != tc.exited {
+				t.Errorf("got %d, %v; want %d, %v", status, exited, tc.status, tc.exited)
+			}
+		})
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		end    func(*micadtest.Server) error
+		status int
+	}{
+		{
+			name: "stop",
+			end: func(srv *micadtest.Server) error {
+				_, err := srv.SocketClient().Stop(context.Background(), "zephyr01")
+				return err
+			},
+			status: monitor.ExitStatusStopped,
+		},
+		{name: "crash", end: func(srv *micadtest.Server) error { return srv.Crash("zephyr01") }, status: monitor.ExitStatusCrashed},
+		{name: "vanish", end: func(srv *micadtest.Server) error { return srv.Vanish("zephyr01") }, status: monitor.ExitStatusCrashed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newRunningClient(t)
+			// hiccups of micad are no exit
+			srv.Script(micadtest.OpStatus, "zephyr01", micadtest.Behavior{Hangup: true}, micadtest.Behavior{Partial: "zephyr01 run"})
+
+			done := make(chan *monitor.Exit, 1)
+			go func() {
+				exit, err := monitor.NewWatcher(srv.SocketClient(), testInterval).Wait(context.Background(), "zephyr01")
+				if err != nil {
+					t.Error(err)
+				}
+				done <- exit
+			}()
+			select {
+			case exit := <-done:
+				t.Fatalf("watcher returned %+v while the client runs", exit)
+			case <-time.After(10 * testInterval):
+			}
+			if err := tc.end(srv); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case exit := <-done:
+				if exit == nil || exit.Status != tc.status {
+					t.Errorf("exit %+v, want status %d", exit, tc.status)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("watcher missed the exit")
+			}
+		})
+	}
+}
+
+func TestWatcherCanceled(t *testing.T) {
+	srv := newRunningClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testInterval)
+	defer cancel()
+	_, err := monitor.NewWatcher(srv.SocketClient(), testInterval).Wait(ctx, "zephyr01")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline", err)
+	}
+}
+
+// newRunningClient returns a micadtest server running client zephyr01.
+func newRunningClient(t *testing.T) *micadtest.Server {
+	t.Helper()
+	srv, err := micadtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	micad := srv.SocketClient()
+	msg := &communication.CreateMsg{CPU: 3}
+	copy(msg.Name[:], "zephyr01")
+	if _, err := micad.Create(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := micad.Start(context.Background(), "zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
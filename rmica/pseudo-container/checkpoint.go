@@ -0,0 +1,254 @@
+package pseudo_container
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"rmica/communication"
+	"rmica/defs"
+	"rmica/logger"
+	"rmica/monitor"
+	"rmica/utils"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// checkpointVersion is the layout of mica-checkpoint.json.
+const checkpointVersion = 1
+
+// checkpointImage is what `rmica checkpoint` keeps of a client in
+// <image path>/mica-checkpoint.json: enough to create it again with micad,
+// and the state micad exported, if it could, in client-state.img.
+type checkpointImage struct {
+	Version  int       `json:"version"`
+	ID       string    `json:"id"`
+	Created  time.Time `json:"created"`
+	Client   string    `json:"client"`
+	CPU      uint32    `json:"cpu"`
+	Firmware string    `json:"firmware"`
+	// FirmwareDigest guards against restoring onto another firmware
+	FirmwareDigest string `json:"firmwareDigest"`
+	Pedestal       string `json:"pedestal,omitempty"`
+	PedestalConf   string `json:"pedestalConf,omitempty"`
+	Debug          bool   `json:"debug,omitempty"`
+	// ClientState is set when client-state.img holds the exported state
+	ClientState bool `json:"clientState,omitempty"`
+}
+
+// createMsg rebuilds the message that creates the client again.
+func (img *checkpointImage) createMsg() (*communication.CreateMsg, error) {
+	annotations := map[string]string{
+		defs.MicaAnnoClientName:     img.Client,
+		defs.MicaAnnoClientCPU:      strconv.FormatUint(uint64(img.CPU), 10),
+		defs.MicaAnnoClientFirmware: img.Firmware,
+		defs.MicaAnnoDebug:          strconv.FormatBool(img.Debug),
+	}
+	if img.Pedestal != "" {
+		annotations[defs.MicaAnnoPedestal] = img.Pedestal
+		annotations[defs.MicaAnnoPedestalConf] = img.PedestalConf
+	}
+	// the firmware path was resolved at checkpoint time already
+	return communication.ParseAnnotations(annotations, img.Client, "")
+}
+
+// Checkpoint writes the client configuration, firmware reference, CPU
+// assignment and whatever state micad can export to the image directory in
+// criuOpts. Unless criuOpts.LeaveRunning the client is stopped and removed
+// afterwards, like runc leaves a checkpointed container stopped.
+func (c *Container) Checkpoint(criuOpts *libcontainer.CriuOpts) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if criuOpts == nil || criuOpts.ImagesDirectory == "" {
+		return errors.New("checkpoint needs an image path")
+	}
+	switch c.cstate.status() {
+	case specs.StateRunning, StatePaused:
+	default:
+		return fmt.Errorf("cannot checkpoint container %s: %w", c.id, utils.ErrNotRunning)
+	}
+	if c.config == nil {
+		return fmt.Errorf("container %s has no recorded config to checkpoint", c.id)
+	}
+
+	ctx := context.Background()
+	client := c.client()
+	logger.Infof("[container] checkpoint client %s of id=%s to %s", client, c.id, criuOpts.ImagesDirectory)
+	st, err := c.micad.Status(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to query client %s: %w", client, err)
+	}
+	msg, err := communication.ParseAnnotations(c.config.Annotations, c.id, utils.RootfsIn(c.bundle, c.config))
+	if err != nil {
+		return err
+	}
+	digest, err := fileDigest(msg.Firmware())
+	if err != nil {
+		return fmt.Errorf("failed to fingerprint firmware: %w", err)
+	}
+	img := &checkpointImage{
+		Version:        checkpointVersion,
+		ID:             c.id,
+		Created:        time.Now().UTC(),
+		Client:         client,
+		CPU:            st.CPU,
+		Firmware:       msg.Firmware(),
+		FirmwareDigest: digest,
+		Pedestal:       msg.Pedestal(),
+		PedestalConf:   msg.PedestalConf(),
+		Debug:          msg.Debug,
+	}
+
+	if err := os.MkdirAll(criuOpts.ImagesDirectory, 0o700); err != nil {
+		return err
+	}
+	state, err := c.micad.Checkpoint(ctx, client)
+	switch {
+	case err == nil:
+		statePath := filepath.Join(criuOpts.ImagesDirectory, defs.ClientStateFilename)
+		if err := os.WriteFile(statePath, state, 0o600); err != nil {
+			return err
+		}
+		img.ClientState = true
+	case errors.Is(err, communication.ErrNotSupported):
+		logger.Warnf("[container] micad cannot export client %s, it will boot afresh on restore", client)
+	default:
+		return fmt.Errorf("failed to checkpoint client %s: %w", client, err)
+	}
+	if err := writeCheckpointImage(criuOpts.ImagesDirectory, img); err != nil {
+		return err
+	}
+
+	if criuOpts.LeaveRunning {
+		return nil
+	}
+	if _, err := c.micad.Stop(ctx, client); err != nil {
+		logger.Warnf("[container] stop %s after checkpoint failed: %v", client, err)
+	}
+	if _, err := c.micad.Remove(ctx, client); err != nil {
+		return fmt.Errorf("failed to remove client %s after checkpoint: %w", client, err)
+	}
+	return c.markStopped(monitor.NewExit(monitor.ExitStatusStopped))
+}
+
+// restore creates the client described by the image in imagePath and boots
+// it, from the exported state if there is one and micad can load it.
+func (c *Container) restore(imagePath string) error {
+	logger.Infof("[container] restore called for id=%s from %s", c.id, imagePath)
+	img, err := readCheckpointImage(imagePath)
+	if err != nil {
+		return err
+	}
+	digest, err := fileDigest(img.Firmware)
+	if err != nil {
+		return fmt.Errorf("failed to fingerprint firmware: %w", err)
+	}
+	if digest != img.FirmwareDigest {
+		return fmt.Errorf("firmware %s changed since the checkpoint (%s, was %s)",
+			img.Firmware, digest, img.FirmwareDigest)
+	}
+	msg, err := img.createMsg()
+	if err != nil {
+		return fmt.Errorf("bad checkpoint image %s: %w", imagePath, err)
+	}
+
+	ctx := context.Background()
+	if _, err := c.micad.Create(ctx, msg); err != nil {
+		return fmt.Errorf("failed to create client %s: %w", img.Client, err)
+	}
+	c.clientName = img.Client
+	// the client runs where it was checkpointed, whatever the bundle says
+	if c.config != nil && c.config.Annotations[defs.MicaAnnoClientCPU] != strconv.FormatUint(uint64(img.CPU), 10) {
+		logger.Warnf("[container] client %s of id=%s restored on cpu %d, not on cpu %s of %s",
+			img.Client, c.id, img.CPU, c.config.Annotations[defs.MicaAnnoClientCPU], defs.SpecConfig)
+		c.config = withClientCPU(c.config, img.CPU)
+	}
+	if _, err := c.updateState(nil); err != nil {
+		return err
+	}
+	if err := c.createHooks(); err != nil {
+		return err
+	}
+	if err := c.startContainerHooks(); err != nil {
+		return err
+	}
+	if err := c.bootRestored(ctx, img, imagePath); err != nil {
+		return err
+	}
+	c.warnHooks(HookPoststart, specs.StateRunning)
+	return nil
+}
+
+// bootRestored boots the client of img from its exported state, or afresh
+// if there is none or micad cannot load it.
+func (c *Container) bootRestored(ctx context.Context, img *checkpointImage, imagePath string) error {
+	if img.ClientState {
+		state, err := os.ReadFile(filepath.Join(imagePath, defs.ClientStateFilename))
+		if err != nil {
+			return err
+		}
+		_, err = c.micad.Restore(ctx, img.Client, state)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, communication.ErrNotSupported) {
+			return fmt.Errorf("failed to restore client %s: %w", img.Client, err)
+		}
+		logger.Warnf("[container] micad cannot load the state of client %s, booting it afresh", img.Client)
+	}
+	if _, err := c.micad.Start(ctx, img.Client); err != nil {
+		return fmt.Errorf("failed to start client %s: %w", img.Client, err)
+	}
+	return nil
+}
+
+func writeCheckpointImage(dir string, img *checkpointImage) error {
+	f, err := os.OpenFile(filepath.Join(dir, defs.CheckpointFilename), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteJSON(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readCheckpointImage(dir string) (*checkpointImage, error) {
+	path := filepath.Join(dir, defs.CheckpointFilename)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img := &checkpointImage{}
+	if err := json.Unmarshal(data, img); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	if img.Version != checkpointVersion {
+		return nil, fmt.Errorf("%s has version %d, this rmica only reads %d", path, img.Version, checkpointVersion)
+	}
+	return img, nil
+}
+
+// fileDigest is the sha256 of the file at path as "sha256:<hex>".
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,176 @@
+package pseudo_container
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"rmica/communication"
+	"rmica/defs"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// newBundle makes a bundle whose rootfs ships the firmware of a client on
+// cpu, and returns it with the firmware path on the host.
+func newBundle(t *testing.T, cpu string) (string, *specs.Spec, string) {
+	t.Helper()
+	bundle := t.TempDir()
+	firmware := filepath.Join(bundle, "rootfs/lib/firmware/zephyr.elf")
+	if err := os.MkdirAll(filepath.Dir(firmware), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(firmware, []byte("\x7fELF zephyr"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec := &specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: "rootfs"},
+		Annotations: map[string]string{
+			defs.MicaAnnoClientCPU:      cpu,
+			defs.MicaAnnoClientFirmware: "/lib/firmware/zephyr.elf",
+		},
+	}
+	return bundle, spec, firmware
+}
+
+// checkpointed checkpoints the running client zephyr01 on cpu 3 into a new
+// image directory, with exported state if micad has any.
+func checkpointed(t *testing.T, fake *communication.FakeClient, state []byte) (string, string) {
+	t.Helper()
+	c := newFakeContainer(t, fake, specs.StateRunning)
+	bundle, spec, firmware := newBundle(t, "3")
+	c.bundle, c.config = bundle, spec
+	if state != nil {
+		fake.States["zephyr01"] = state
+	}
+	imagePath := filepath.Join(t.TempDir(), "image")
+	if err := c.Checkpoint(&libcontainer.CriuOpts{ImagesDirectory: imagePath}); err != nil {
+		t.Fatal(err)
+	}
+	return imagePath, firmware
+}
+
+// restored restores imagePath into a new created container of a bundle
+// asking for cpu.
+func restored(t *testing.T, fake *communication.FakeClient, imagePath, cpu string) (*Container, error) {
+	t.Helper()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	delete(fake.Known, "zephyr01")
+	c.clientName = ""
+	c.bundle, c.config, _ = newBundle(t, cpu)
+	fake.Calls = nil
+	return c, c.Restore(&libcontainer.CriuOpts{ImagesDirectory: imagePath})
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	fake := communication.NewFakeClient()
+	imagePath, firmware := checkpointed(t, fake, []byte("zephyr registers"))
+
+	img, err := readCheckpointImage(imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := fileDigest(firmware)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &checkpointImage{
+		Version:        checkpointVersion,
+		ID:             "zephyr01",
+		Created:        img.Created,
+		Client:         "zephyr01",
+		CPU:            3,
+		Firmware:       firmware,
+		FirmwareDigest: digest,
+		ClientState:    true,
+	}
+	if !reflect.DeepEqual(img, want) {
+		t.Errorf("image %+v, want %+v", img, want)
+	}
+	state, err := os.ReadFile(filepath.Join(imagePath, defs.ClientStateFilename))
+	if err != nil || string(state) != "zephyr registers" {
+		t.Errorf("client state %q, %v", state, err)
+	}
+	if _, ok := fake.Known["zephyr01"]; ok {
+		t.Error("checkpoint left the client behind")
+	}
+
+	c, err := restored(t, fake, imagePath, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"create zephyr01", "restore zephyr01"}; !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("micad got %q, want %q", fake.Calls, want)
+	}
+	if cs := fake.Known["zephyr01"]; cs == nil || cs.CPU != 3 || !cs.Running() {
+		t.Errorf("client %+v, want it running on cpu 3", cs)
+	}
+	if !bytes.Equal(fake.States["zephyr01"], state) {
+		t.Errorf("micad restored %q, want %q", fake.States["zephyr01"], state)
+	}
+	if c.ClientName() != "zephyr01" {
+		t.Errorf("client name %q", c.ClientName())
+	}
+}
+
+// Without exported state the client boots afresh.
+func TestRestoreWithoutClientState(t *testing.T) {
+	fake := communication.NewFakeClient()
+	imagePath, _ := checkpointed(t, fake, nil)
+	if _, err := os.Stat(filepath.Join(imagePath, defs.ClientStateFilename)); !os.IsNotExist(err) {
+		t.Errorf("%s written without state: %v", defs.ClientStateFilename, err)
+	}
+	if _, err := restored(t, fake, imagePath, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"create zephyr01", "start zephyr01"}; !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("micad got %q, want %q", fake.Calls, want)
+	}
+}
+
+func TestRestoreFirmwareChanged(t *testing.T) {
+	fake := communication.NewFakeClient()
+	imagePath, firmware := checkpointed(t, fake, []byte("zephyr registers"))
+	if err := os.WriteFile(firmware, []byte("\x7fELF nuttx"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := restored(t, fake, imagePath, "3")
+	if err == nil || !strings.Contains(err.Error(), "changed since the checkpoint") {
+		t.Fatalf("got %v, want the firmware change reported", err)
+	}
+	if len(fake.Calls) != 0 {
+		t.Errorf("micad got %q", fake.Calls)
+	}
+}
+
+// The client comes back on the cpu it was checkpointed on, and the recorded
+// config says so.
+func TestRestoreOnCheckpointedCPU(t *testing.T) {
+	fake := communication.NewFakeClient()
+	imagePath, _ := checkpointed(t, fake, []byte("zephyr registers"))
+	c, err := restored(t, fake, imagePath, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs := fake.Known["zephyr01"]; cs == nil || cs.CPU != 3 {
+		t.Fatalf("client %+v, want it on cpu 3", cs)
+	}
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []*specs.Spec{c.Config(), rec.Config} {
+		if cpu := config.Annotations[defs.MicaAnnoClientCPU]; cpu != "3" {
+			t.Errorf("%s is %q, want 3", defs.MicaAnnoClientCPU, cpu)
+		}
+		if config.Linux == nil || config.Linux.Resources == nil || config.Linux.Resources.CPU == nil ||
+			config.Linux.Resources.CPU.Cpus != "3" {
+			t.Errorf("linux.resources.cpu of %+v, want cpus 3", config.Linux)
+		}
+	}
+}
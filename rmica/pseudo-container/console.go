@@ -0,0 +1,278 @@
+package pseudo_container
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+
+	"rmica/defs"
+	"rmica/logger"
+	"rmica/utils"
+
+	"github.com/containerd/console"
+	"golang.org/x/sys/unix"
+)
+
+// ConsoleDevice is the host end of the client console: the device the
+// console annotation names, or else the tty micad lists among the services
+// of the client. It is "" while micad exposes none, e.g. before the client
+// booted.
+func (c *Container) ConsoleDevice() (string, error) {
+	if c.config != nil {
+		if dev := c.config.Annotations[defs.MicaAnnoClientConsole]; dev != "" {
+			return dev, nil
+		}
+	}
+	st, err := c.ClientStatus()
+	if err != nil {
+		return "", err
+	}
+	return st.TTY(), nil
+}
+
+// setupConsole hands the master of a new pty to the console socket and
+// leaves its slave to `rmica proxy`, which outlives create.
+func (r *runner) setupConsole() error {
+	tty, err := utils.SetupIO(r.consoleSocket)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	return r.container.startProxy(tty.Slave)
+}
+
+// startProxy spawns `rmica proxy` in a session of its own. With a tty, the
+// proxy gets it as fd 3 and controlling terminal, so that resizing the
+// master reaches it as SIGWINCH. Without, it inherits the stdio create got
+// from the container engine.
+func (c *Container) startProxy(tty *os.File) error {
+	cmd, err := c.proxyCommand(tty)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start console proxy of container %s: %w", c.id, err)
+	}
+	logger.Debugf("console proxy of container %s is pid %d", c.id, cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// proxyCommand returns the `rmica proxy` startProxy spawns for tty, nil
+// for none.
+func (c *Container) proxyCommand(tty *os.File) (*exec.Cmd, error) {
+	args := []string{"proxy"}
+	if tty != nil {
+		args = append(args, "--tty")
+	}
+	cmd, err := c.command(append(args, c.id)...)
+	if err != nil {
+		return nil, err
+	}
+	if tty != nil {
+		cmd.ExtraFiles = []*os.File{tty}
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 3
+	} else {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	}
+	return cmd, nil
+}
+
+// consoleProxy copies between the client console and either the slave of
+// the pty the console socket got or the stdio of the container. The client
+// console comes and goes with the client, the other end stays.
+type consoleProxy struct {
+	in  io.Reader
+	out io.Writer
+	// tty is the pty slave, nil when forwarding stdio
+	tty console.Console
+
+	mu sync.Mutex
+	// dev is the client console, nil while there is none
+	dev     *os.File
+	devTerm console.Console
+	closed  bool
+}
+
+// ProxyConsole runs the console proxy on tty until the container stopped or
+// the master of the pty was closed. interval is how often it looks for the
+// client console while there is none.
+func (c *Container) ProxyConsole(tty *os.File, interval time.Duration) error {
+	term, err := console.ConsoleFromFile(tty)
+	if err != nil {
+		return fmt.Errorf("%s is not a terminal: %w", tty.Name(), err)
+	}
+	// the line discipline is the business of the client shell
+	if err := term.SetRaw(); err != nil {
+		return err
+	}
+	p := &consoleProxy{in: term, out: term, tty: term}
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			p.resize()
+		}
+	}()
+	return c.proxy(p, interval)
+}
+
+// ForwardStdio forwards the console of a client without terminal: what the
+// client prints goes to stdout, where the container engine collects its
+// logs, and stdin goes to the client for as long as there is any. It runs
+// until the container stopped or stdout went away.
+func (c *Container) ForwardStdio(stdin io.Reader, stdout io.Writer, interval time.Duration) error {
+	return c.proxy(&consoleProxy{in: stdin, out: stdout}, interval)
+}
+
+func (c *Container) proxy(p *consoleProxy, interval time.Duration) error {
+	go p.input()
+	for {
+		if p.isClosed() {
+			logger.Debugf("console of container %s was closed", c.id)
+			return nil
+		}
+		if c.stoppedOnDisk() {
+			logger.Debugf("container %s stopped, console proxy leaves", c.id)
+			return nil
+		}
+		path, err := c.ConsoleDevice()
+		if err != nil || path == "" {
+			logger.Debugf("no console for client %s yet: %v", c.client(), err)
+			time.Sleep(interval)
+			continue
+		}
+		if err := p.attach(path); err != nil {
+			logger.Debugf("cannot open console %s of client %s: %v", path, c.client(), err)
+			time.Sleep(interval)
+			continue
+		}
+		logger.Debugf("proxying console %s of client %s", path, c.client())
+		err = p.output()
+		logger.Debugf("console %s of client %s went away: %v", path, c.client(), err)
+		p.detach()
+		time.Sleep(interval)
+	}
+}
+
+// stoppedOnDisk tells from state.json whether the container is gone or its
+// client stopped, another rmica keeps it up to date.
+func (c *Container) stoppedOnDisk() bool {
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		return errors.Is(err, utils.ErrNotExist)
+	}
+	return rec.Exit != nil
+}
+
+func (p *consoleProxy) attach(path string) error {
+	dev, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		dev.Close()
+		return errors.New("console closed")
+	}
+	p.dev = dev
+	// the console annotation may name something that is not a tty
+	if term, err := console.ConsoleFromFile(dev); err == nil {
+		if err := term.SetRaw(); err != nil {
+			logger.Warnf("cannot make console %s raw: %v", path, err)
+		}
+		p.devTerm = term
+		p.resizeLocked()
+	}
+	return nil
+}
+
+func (p *consoleProxy) detach() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dev != nil {
+		p.dev.Close()
+	}
+	p.dev, p.devTerm = nil, nil
+}
+
+// input forwards what is typed on the pty or comes on stdin, dropping it
+// while there is no client console. The proxy ends with the pty, stdin may
+// well be closed from the start.
+func (p *consoleProxy) input() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := p.in.Read(buf)
+		if n > 0 {
+			p.mu.Lock()
+			if p.dev != nil {
+				p.dev.Write(buf[:n])
+			}
+			p.mu.Unlock()
+		}
+		if err != nil {
+			if p.tty != nil {
+				p.close()
+			}
+			return
+		}
+	}
+}
+
+// output copies what the client prints until its console goes away. Failing
+// to write it ends the proxy, nobody is listening anymore.
+func (p *consoleProxy) output() error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := p.dev.Read(buf)
+		if n > 0 {
+			if _, werr := p.out.Write(buf[:n]); werr != nil {
+				p.close()
+				return werr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *consoleProxy) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.dev != nil {
+		// unblock output
+		p.dev.Close()
+	}
+}
+
+func (p *consoleProxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *consoleProxy) resize() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resizeLocked()
+}
+
+// resizeLocked passes the size of the pty on to the client console.
+func (p *consoleProxy) resizeLocked() {
+	if p.tty == nil || p.devTerm == nil {
+		return
+	}
+	if err := p.devTerm.ResizeFrom(p.tty); err != nil {
+		logger.Debugf("cannot resize console %s: %v", p.devTerm.Name(), err)
+	}
+}
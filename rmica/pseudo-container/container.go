@@ -1,12 +1,16 @@
 package pseudo_container
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -15,6 +19,7 @@ import (
 	"rmica/defs"
 	"rmica/logger"
 	"rmica/mcs"
+	"rmica/monitor"
 	"rmica/utils"
 
 	"github.com/opencontainers/runc/libcontainer"
@@ -31,6 +36,8 @@ type Container struct {
 	id      string
 	// from global flag --root: 
 	root    string
+	// absolute path of the bundle the container was created from
+	bundle  string
 	// stateDir = root/id
 	// stateDir string
 	// Use specs-go::Spec, State to represent, following OCI-spec
@@ -38,6 +45,18 @@ type Container struct {
 	cstate   ContainerState
 	initPid int
 	created time.Time
+	// name of the client that micad manages for this container
+	clientName string
+	// directory of the micad sockets the client lives behind
+	micaDir    string
+	micad      communication.MicadClient
+	// global flags besides --root this rmica was called with, passed on
+	// to the monitor and the console proxy
+	globalArgs []string
+	// how the client stopped, nil until it did
+	exit       *monitor.Exit
+	// pid of the rmica update recreating the client, see beginUpdate
+	updating   int
 	m 			sync.Mutex
 	// TODO: MCS client manager, will defined in mcs.go
 	// clientManager *clientManager
@@ -54,6 +73,8 @@ type runner struct {
 	notifySocket  *notifySocket
 	consoleSocket string
 	criuOpts      *libcontainer.CriuOpts
+	// client micad creates for CT_ACT_CREATE and CT_ACT_RUN
+	createMsg     *communication.CreateMsg
 }
 
 
@@ -67,6 +88,33 @@ func (c *Container) Root() string {
 	return c.root
 }
 
+func (c *Container) Bundle() string {
+	return c.bundle
+}
+
+func (c *Container) Created() time.Time {
+	return c.created
+}
+
+// Config is the spec the container was created from, nil for a container
+// created before rmica recorded it.
+func (c *Container) Config() *specs.Spec {
+	return c.config
+}
+
+func (c *Container) ClientName() string {
+	return c.client()
+}
+
+// client is the micad client name to address, falling back to the
+// demo task until create records one.
+func (c *Container) client() string {
+	if c.clientName != "" {
+		return c.clientName
+	}
+	return utils.GetMicaTaskConfig().Name
+}
+
 func (c *Container) StateDir() string {
 	// return c.stateDir
 	return filepath.Join(c.root, c.id)
@@ -86,13 +134,23 @@ func (c *Container) Status() specs.ContainerState {
 func (c *Container) State() specs.State {
 	c.m.Lock()
 	defer c.m.Unlock()
+	return c.currentState()
+}
+
+// currentState builds the OCI state without taking c.m, callers must hold it.
+func (c *Container) currentState() specs.State {
 	state := specs.State{
-		Version:     specs.Version,
-		ID:          c.Id(),
-		Status:      c.cstate.status(),
-		Pid:         c.initPid,
-		Bundle:      c.Root(),
-		Annotations: c.config.Annotations,
+		Version: specs.Version,
+		ID:      c.Id(),
+		Status:  c.cstate.status(),
+		Bundle:  c.bundle,
+	}
+	// like runc, a stopped container has no pid
+	if state.Status != specs.StateStopped {
+		state.Pid = c.initPid
+	}
+	if c.config != nil {
+		state.Annotations = c.config.Annotations
 	}
 	return state
 }
@@ -104,7 +162,7 @@ func (c *Container) State() specs.State {
 func (c *Container) OCIState() *specs.State {
 	c.m.Lock()
 	defer c.m.Unlock()
-	state := c.State()
+	state := c.currentState()
 	return &state
 }
 
@@ -115,26 +173,60 @@ func (c *Container) ignoreCgroupError(err error) error {
 	return nil
 }
 
-// TODO: ClientProcesses returns the PIDs of the handler of client RTOS ()
-// which marker should we focus on?
+// ClientProcesses returns the PIDs of the host-side handler of the client
+// RTOS, the tasks inside the client are listed by Tasks.
 func (c *Container) ClientProcesses() ([]int, error) {
+	if !c.hasInit() {
+		return []int{}, nil
+	}
 	return []int{c.initPid}, nil
 }
 
-// FIXME: the statistics function is 0% implemented
-// Stats returns statistics for the container.
+// Tasks asks micad for the tasks running on the client. It fails with
+// communication.ErrNotSupported if micad cannot list them.
+func (c *Container) Tasks() ([]communication.Task, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.micad.Tasks(context.Background(), c.client())
+}
+
+// Stats returns statistics for the container, as far as micad reports them.
 func (c *Container) Stats() (*Stats, error) {
-	// c.m.Lock()
-	// defer c.m.Unlock()
+	st, err := c.ClientStatus()
+	if err != nil {
+		return nil, err
+	}
+	return StatsFromStatus(st), nil
+}
+
+// StatsFromStatus builds the statistics of a client from the status micad
+// reported for it.
+func StatsFromStatus(st *communication.ClientStatus) *Stats {
 	stats := NewEmpty()
-	return &stats, nil
+	stats.ClientStates = &mcs.ClientStats{
+		CoreStats: mcs.CpuStats{Cpu: st.CPU},
+		Name:      st.Name,
+		State:     string(st.State),
+		Service:   st.Service,
+	}
+	if online, err := utils.OnlineCPUs(); err == nil {
+		stats.ClientStates.CoreStats.MaxCpu = uint32(len(online))
+	}
+	return &stats
+}
+
+// ClientStatus asks micad how the client is doing right now.
+func (c *Container) ClientStatus() (*communication.ClientStatus, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.micad.Status(context.Background(), c.client())
 }
 
 func (c *Container) Set(config *specs.Spec) error {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	status := c.Status()
+	status := c.cstate.status()
 	if status == specs.StateStopped {
 		return utils.ErrNotRunning
 	}
@@ -182,36 +274,71 @@ func (c *Container) fakeStart() error {
 }
 
 func (c *Container) start() error {
-
-	logger.Debugf("createExecFifo for id=%s", c.id)
-	// if err := c.createExecFifo(defs.ExecFifoFilename); err != nil {
-	// 	return fmt.Errorf("failed to create exec fifo: %w", err)
-	// }
-
-
-	res := communication.Send2mica("start")
-	if res == "" {
-		return fmt.Errorf("failed to send start to micad or got empty response")
+	if err := c.startContainerHooks(); err != nil {
+		return err
 	}
-
+	if _, err := c.micad.Start(context.Background(), c.client()); err != nil {
+		return fmt.Errorf("failed to start client %s: %w", c.client(), err)
+	}
+	c.warnHooks(HookPoststart, specs.StateRunning)
 	return nil
 }
 
+// Exec boots the client of a created container, releasing the monitor that
+// create left blocked on exec.fifo. A client that fails to boot leaves the
+// container stopped.
 func (c *Container) Exec() error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return c.exec()
+	if c.cstate.status() == specs.StateCreated {
+		if err := c.releaseExecFifo(); err != nil {
+			return err
+		}
+	}
+	if err := c.exec(); err != nil {
+		if c.cstate.status() == specs.StateCreated {
+			if serr := c.markStopped(monitor.NewExit(monitor.ExitStatusCrashed)); serr != nil {
+				logger.Warnf("container %s: %v", c.id, serr)
+			}
+		}
+		return err
+	}
+	if err := c.cstate.transition(&RunningState{c: c}); err != nil {
+		return err
+	}
+	_, err := c.updateState(nil)
+	return err
 }
 
+// exec makes sure the client of a created container is booted.
 func (c *Container) exec() error {
 	logger.Infof("[container] exec called for id=%s", c.id)
-	res := communication.Send2mica("exec")
-	if res == "" {
-		logger.Errorf("[container] exec failed for id=%s: empty response from micad", c.id)
-		return fmt.Errorf("failed to send exec to micad or got empty response")
+	st, err := c.micad.Status(context.Background(), c.client())
+	if err != nil {
+		logger.Errorf("[container] exec failed for id=%s: %v", c.id, err)
+		return fmt.Errorf("failed to query client %s: %w", c.client(), err)
 	}
-	logger.Infof("[container] exec succeeded for id=%s, response=%s", c.id, res)
-	return nil
+	if st.Running() {
+		logger.Infof("[container] client %s of id=%s already running", st.Name, c.id)
+		return nil
+	}
+	return c.start()
+}
+
+// Register asks micad to create the client described by msg and records the
+// client name in the container state, later commands address it by that name.
+func (c *Container) Register(msg *communication.CreateMsg) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	name := msg.ClientName()
+	logger.Infof("[container] create client %s on cpu %d for id=%s", name, msg.CPU, c.id)
+	if _, err := c.micad.Create(context.Background(), msg); err != nil {
+		logger.Errorf("[container] create client %s failed for id=%s: %v", name, c.id, err)
+		return fmt.Errorf("failed to create client %s: %w", name, err)
+	}
+	c.clientName = name
+	_, err := c.updateState(nil)
+	return err
 }
 
 func (c *Container) Run() error {
@@ -222,11 +349,14 @@ func (c *Container) Run() error {
 
 func (c *Container) run() error {
 	logger.Infof("[container] run called for id=%s", c.id)
-	res := communication.Send2mica("run")
-	if res == "" {
-		logger.Errorf("[container] run failed for id=%s: empty response from micad", c.id)
-		return fmt.Errorf("failed to send run to micad or got empty response")
+	if err := c.startContainerHooks(); err != nil {
+		return err
 	}
+	if _, err := c.micad.Start(context.Background(), c.client()); err != nil {
+		logger.Errorf("[container] run failed for id=%s: %v", c.id, err)
+		return fmt.Errorf("failed to run client %s: %w", c.client(), err)
+	}
+	c.warnHooks(HookPoststart, specs.StateRunning)
 	return nil
 }
 
@@ -238,15 +368,18 @@ func (c *Container) Stop() error {
 
 func (c *Container) stop() error {
 	logger.Infof("[container] stop called for id=%s", c.id)
-	res := communication.Send2mica("stop")
-	if res == "" {
-		logger.Errorf("[container] stop failed for id=%s: empty response from micad", c.id)
-		return fmt.Errorf("failed to send stop to micad or got empty response")
+	reply, err := c.micad.Stop(context.Background(), c.client())
+	if err != nil {
+		logger.Errorf("[container] stop failed for id=%s: %v", c.id, err)
+		return fmt.Errorf("failed to stop client %s: %w", c.client(), err)
 	}
-	logger.Infof("[container] stop succeeded for id=%s, response=%s", c.id, res)
+	logger.Infof("[container] stop succeeded for id=%s, response=%s", c.id, reply.Output)
 	return nil
 }
 
+// Pause suspends the client and persists the container as paused. Only a
+// created or running container can be paused, anything else fails with a
+// *StateTransitionError before micad is asked.
 func (c *Container) Pause() error {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -255,15 +388,23 @@ func (c *Container) Pause() error {
 
 func (c *Container) pause() error {
 	logger.Infof("[container] pause called for id=%s", c.id)
-	res := communication.Send2mica("pause")
-	if res == "" {
-		logger.Errorf("[container] pause failed for id=%s: empty response from micad", c.id)
-		return fmt.Errorf("failed to send pause to micad or got empty response")
+	from := c.cstate
+	if err := c.cstate.transition(&PausedState{c: c}); err != nil {
+		return err
 	}
-	logger.Infof("[container] pause succeeded for id=%s, response=%s", c.id, res)
-	return nil
+	reply, err := c.micad.Pause(context.Background(), c.client())
+	if err != nil {
+		c.cstate = from
+		logger.Errorf("[container] pause failed for id=%s: %v", c.id, err)
+		return fmt.Errorf("failed to pause client %s: %w", c.client(), err)
+	}
+	logger.Infof("[container] pause succeeded for id=%s, response=%s", c.id, reply.Output)
+	_, err = c.updateState(nil)
+	return err
 }
 
+// Resume wakes up the client of a paused container and persists it as
+// running, any other container fails with a *StateTransitionError.
 func (c *Container) Resume() error {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -272,29 +413,33 @@ func (c *Container) Resume() error {
 
 func (c *Container) resume() error {
 	logger.Infof("[container] resume called for id=%s", c.id)
-	res := communication.Send2mica("resume")
-	if res == "" {
-		logger.Errorf("[container] resume failed for id=%s: empty response from micad", c.id)
-		return fmt.Errorf("failed to send resume to micad or got empty response")
+	from := c.cstate
+	if from.status() != StatePaused {
+		return newStateTransitionError(from, &RunningState{c: c})
 	}
-	logger.Infof("[container] resume succeeded for id=%s, response=%s", c.id, res)
-	return nil
+	if err := c.cstate.transition(&RunningState{c: c}); err != nil {
+		return err
+	}
+	reply, err := c.micad.Resume(context.Background(), c.client())
+	if err != nil {
+		c.cstate = from
+		logger.Errorf("[container] resume failed for id=%s: %v", c.id, err)
+		return fmt.Errorf("failed to resume client %s: %w", c.client(), err)
+	}
+	logger.Infof("[container] resume succeeded for id=%s, response=%s", c.id, reply.Output)
+	_, err = c.updateState(nil)
+	return err
 }
 
-func (c *Container) Restore() error {
+// Restore recreates the client from the checkpoint image in
+// criuOpts.ImagesDirectory, see checkpoint.go.
+func (c *Container) Restore(criuOpts *libcontainer.CriuOpts) error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return c.restore()
-}
-
-func (c *Container) restore() error {
-	logger.Infof("[container] restore called for id=%s", c.id)
-	res := communication.Send2mica("restore")
-	if res == "" {
-		logger.Errorf("[container] restore failed for id=%s: empty response from micad", c.id)
-		return fmt.Errorf("failed to send restore to micad or got empty response")
+	if criuOpts == nil || criuOpts.ImagesDirectory == "" {
+		return errors.New("restore needs an image path")
 	}
-	return nil
+	return c.restore(criuOpts.ImagesDirectory)
 }
 
 func (c *Container) Destroy() error {
@@ -319,28 +464,200 @@ func (c *Container) Destroy() error {
 // 	return nil
 // }
 
-// TODO: Analyse mica signal handling; currently we just wrap the signal to mica
+// Signal maps a POSIX signal onto the micad operations for the client target:
+//
+//	SIGTERM          -> stop (graceful shutdown of the client OS)
+//	SIGKILL          -> stop + rm
+//	SIGSTOP, SIGTSTP -> pause
+//	SIGCONT          -> resume
+//	0                -> liveness probe, fails with ErrNotRunning if micad lost the client
 func (c *Container) Signal(sig os.Signal, target mcs.ClientTask) error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	// TODO: after the communication is established, send the wrapped-signal to micad
 	return c.signal(sig, target.Name)
 }
 
 func (c *Container) signal(sig os.Signal, target string) error {
+	if sig == unix.Signal(0) {
+		return c.probe(target)
+	}
+	// Our local view may be stale, micad decides whether the client still exists.
+	if c.cstate.status() == specs.StateStopped {
+		if err := c.probe(target); err != nil {
+			return err
+		}
+	}
+
+	switch sig {
+	case unix.SIGTERM, unix.SIGKILL:
+		return c.kill(sig.(unix.Signal), target)
+	case unix.SIGSTOP, unix.SIGTSTP:
+		return c.pause()
+	case unix.SIGCONT:
+		return c.resume()
+	}
+	logger.Fprintf("signal %s has not supported yet", sig)
+	logger.Debugf("signal %s has not supported yet", sig)
+	return fmt.Errorf("signal %s: %w", sig, utils.ErrNotImplemented)
+}
+
+// kill stops the client target, and removes it for SIGKILL. It holds the
+// state lock until the exit is recorded, a monitor that sees the client
+// go offline meanwhile then records the exit of the kill rather than its
+// own.
+func (c *Container) kill(sig unix.Signal, target string) error {
+	unlock, err := c.lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := c.micad.Stop(context.Background(), target); err != nil {
+		if sig != unix.SIGKILL {
+			return err
+		}
+		// a client that fails to stop may still be removable
+		logger.Warnf("[container] stop %s before rm failed: %v", target, err)
+	}
 	if sig == unix.SIGKILL {
-		res := communication.Send2micaCmd("stop", target)
-		logger.Debugf("%s <-- mica , for stop %s", res, target)
-		res = communication.Send2micaCmd("rm", target)
-		logger.Debugf("%s <-- mica , for rm %s", res, target)
-	} else {
-		logger.Fprintf("signal %s has not supported yet", sig)
-		logger.Debugf("signal %s has not supported yet", sig)
-		return utils.ErrNotImplemented
+		if _, err := c.micad.Remove(context.Background(), target); err != nil {
+			return err
+		}
+	}
+	return c.markStopped(monitor.NewExit(monitor.KilledStatus(sig)))
+}
+
+// probe asks micad for the status of the client target.
+func (c *Container) probe(target string) error {
+	_, exited, err := monitor.Exited(c.micad.Status(context.Background(), target))
+	if err != nil {
+		// micad itself is in trouble, the client may well be alive
+		return err
+	}
+	if exited {
+		logger.Debugf("probe %s: client is gone", target)
+		return utils.ErrNotRunning
 	}
 	return nil
 }
 
+// markStopped moves the container to stopped after micad shut the client down
+// and persists it together with exit, so that `state`, `wait` and `delete`
+// see the change.
+func (c *Container) markStopped(exit *monitor.Exit) error {
+	if err := c.cstate.transition(&StoppedState{c: c}); err != nil {
+		return err
+	}
+	c.exit = exit
+	_, err := c.updateState(nil)
+	return err
+}
+
+// Exit returns how the client stopped, nil while it has not.
+func (c *Container) Exit() *monitor.Exit {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.exit
+}
+
+// Wait blocks until the client of the container exits, polling micad every
+// interval, and records the exit in the container state. The exit of a
+// container that already stopped is returned right away. The client of a
+// created container is not booted yet, Wait waits for start first.
+func (c *Container) Wait(ctx context.Context, interval time.Duration) (*monitor.Exit, error) {
+	c.m.Lock()
+	if c.cstate.status() == specs.StateCreated {
+		c.m.Unlock()
+		if err := c.waitStart(ctx, interval); err != nil {
+			return nil, err
+		}
+		c.m.Lock()
+	}
+	if c.cstate.status() == specs.StateStopped {
+		exit := c.exit
+		c.m.Unlock()
+		if exit == nil {
+			return nil, fmt.Errorf("container %s stopped without a recorded exit: %w", c.id, utils.ErrNotRunning)
+		}
+		return exit, nil
+	}
+	client := c.client()
+	c.m.Unlock()
+
+	for {
+		exit, err := monitor.NewWatcher(c.micad, interval).Wait(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		exit, err = c.recordExit(exit)
+		if exit != nil || err != nil {
+			return exit, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// recordExit records that the client exited with exit, unless another rmica
+// knows better. It returns nil and no error if the client only went away
+// because `rmica update` creates it again on another cpu.
+func (c *Container) recordExit(exit *monitor.Exit) (*monitor.Exit, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	unlock, err := c.lockState()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if rec, err := readStateRecord(c.StateDir()); err == nil {
+		// a concurrent `rmica kill` knows better why the client stopped
+		if rec.Status == specs.StateStopped && rec.Exit != nil {
+			c.cstate = &StoppedState{c: c}
+			c.exit = rec.Exit
+			return rec.Exit, nil
+		}
+		if rec.updating() {
+			logger.Debugf("client of container %s is being moved by rmica update %d", c.id, rec.Updating)
+			return nil, nil
+		}
+		if rec.Config != nil {
+			// take over the cpu an update recorded
+			c.config = rec.Config
+		}
+	}
+	// an update may have brought the client back since it went away
+	if _, exited, err := monitor.Exited(c.micad.Status(context.Background(), c.client())); err == nil && !exited {
+		logger.Debugf("client of container %s is back", c.id)
+		return nil, nil
+	}
+	if err := c.markStopped(exit); err != nil {
+		return nil, err
+	}
+	return exit, nil
+}
+
+// waitStart polls state.json until the container leaves created, micad
+// reports its client offline until then.
+func (c *Container) waitStart(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.refresh(); err != nil {
+			return err
+		}
+		if c.Status() != specs.StateCreated {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 // ==================== Helper Functions ====================
 
 // HostRootUID returns the root uid for the process on host (always 0 for rmica, no user namespace)
@@ -377,9 +694,11 @@ func (c *Container) createExecFifo(fifoName string) error {
 	return nil
 }
 
+// hasInit tells whether the monitor recorded as the pid of the container
+// still runs.
 func (c *Container) hasInit() bool {
-	return c.initPid != 0
-} 
+	return c.initPid != 0 && unix.Kill(c.initPid, 0) == nil
+}
 
 func (c *Container) saveState(s *specs.State) (retErr error) {
 	tmpFile, err := os.CreateTemp(c.StateDir(), "state-")
@@ -394,7 +713,7 @@ func (c *Container) saveState(s *specs.State) (retErr error) {
 			}
 		}()
 
-		err = utils.WriteJSON(tmpFile, s)
+		err = utils.WriteJSON(tmpFile, c.newStateRecord(s))
 		if err != nil {
 			return err
 		}
@@ -408,8 +727,18 @@ func (c *Container) saveState(s *specs.State) (retErr error) {
 
 }
 
+// setState enters s without transition checks, as runc does once an action
+// has succeeded, and persists it.
+func (c *Container) setState(s ContainerState) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.cstate = s
+	_, err := c.updateState(nil)
+	return err
+}
+
 func (c *Container) updateState(clientProcess *mcs.ClientTask) (*specs.State, error) {
-	state := c.State()
+	state := c.currentState()
 	if err := c.saveState(&state); err != nil {
 		return nil, err
 	}
@@ -422,8 +751,24 @@ func GetContainer(context *cli.Context) (*Container, error) {
 	if id == "" {
 		return nil, utils.ErrEmptyID
 	}
+	return LoadContainer(context, id)
+}
+
+// LoadContainer loads the container id with the micad location and timeout
+// given on the command line.
+func LoadContainer(context *cli.Context, id string) (*Container, error) {
 	root := context.GlobalString("root")
-	return Load(root, id)
+	container, err := Load(root, id, communication.SocketSource{
+		FlagSocket: context.GlobalString("mica-socket"),
+		FlagDir:    context.GlobalString("mica-dir"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := container.applyGlobalFlags(context); err != nil {
+		return nil, err
+	}
+	return container, nil
 }
 
 // ==================== Notify Socket Operations ====================
@@ -519,62 +864,123 @@ func NotifySocketStart(context *cli.Context, notifySocketHost, id string) (*noti
 	return notifySocket, nil
 }
 
+// WaitForContainer stands in for the container towards systemd: it relays
+// what a host-side agent of the client sends to the notify socket, reports
+// READY=1 together with the pid of the monitor once micad reports the client
+// running, and returns when the client exited.
 func (s *notifySocket) WaitForContainer(container *Container) error {
-	state := container.State()
-	return s.fakeSuccessRun(state)
-}
-
-func (s *notifySocket) fakeSuccessRun(state specs.State) error {
-	logger.Info("fakeSuccessRun", "state", state)
-	notifySocketHostAddr := net.UnixAddr{Name: s.host, Net: "unixgram"}
-	client, err := net.DialUnix("unixgram", nil, &notifySocketHostAddr)
+	defer s.Close()
+	client, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.host, Net: "unixgram"})
 	if err != nil {
 		return err
 	}
 	defer client.Close()
+	go s.relay(client)
 
-	ready := []byte("READY=1\n")
-	if _, err := client.Write(ready); err != nil {
+	running, err := waitRunning(container, notifyPollInterval, notifyReadyTimeout)
+	if err != nil {
 		return err
 	}
-
-	mainPid := "MAINPID=12345\n"
-	if _, err := client.Write([]byte(mainPid)); err != nil {
-		return err
+	if running {
+		// the monitor lives as long as the client does
+		if err := notifyHost(client, container.State().Pid); err != nil {
+			return err
+		}
 	}
 
-	// 4. 可选：实现一个简单的 barrier
-	// 创建一个管道用于 barrier 同步
-	pipeR, pipeW, _ := os.Pipe()
-	defer pipeR.Close()
-	defer pipeW.Close()
-
-	_, err = client.Write([]byte("BARRIER=1\n"))
+	exit, err := container.Wait(context.Background(), monitor.DefaultInterval)
 	if err != nil {
 		return err
 	}
-	time.Sleep(time.Hour)
-	// select {} 
+	logger.Infof("[rmica] client of %s exited with status %d", container.Id(), exit.Status)
 	return nil
 }
 
+const (
+	// how often WaitForContainer asks micad whether the client runs yet
+	notifyPollInterval = 100 * time.Millisecond
+	// how long WaitForContainer keeps asking when micad does not answer
+	notifyReadyTimeout = 30 * time.Second
+)
+
+// waitRunning polls micad until the client runs, or reports false if it
+// exited before it ever did. Failures that say nothing about the client,
+// such as a status request timing out, are retried until timeout passed.
+func waitRunning(container *Container, interval, timeout time.Duration) (bool, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(timeout)
+	for {
+		st, err := container.ClientStatus()
+		if err == nil && st.Running() {
+			return true, nil
+		}
+		_, exited, err := monitor.Exited(st, err)
+		if exited {
+			return false, nil
+		}
+		if err != nil {
+			if time.Now().After(deadline) {
+				return false, fmt.Errorf("client of %s did not report running within %s: %w", container.Id(), timeout, err)
+			}
+			logger.Debugf("status of client %s: %v, asking again", container.ClientName(), err)
+		}
+		<-ticker.C
+	}
+}
+
+// relay forwards the datagrams sent to the notify socket until it is
+// closed. READY and MAINPID are left out, micad knows better when the client
+// is ready and rmica which pid systemd should watch.
+func (s *notifySocket) relay(client *net.UnixConn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.socket.Read(buf)
+		if err != nil {
+			return
+		}
+		var out [][]byte
+		for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+			if len(line) == 0 || bytes.HasPrefix(line, []byte("READY=")) || bytes.HasPrefix(line, []byte("MAINPID=")) {
+				continue
+			}
+			out = append(out, line)
+		}
+		if len(out) == 0 {
+			continue
+		}
+		if _, err := client.Write(append(bytes.Join(out, []byte{'\n'}), '\n')); err != nil {
+			logger.Warnf("failed to relay %q to %s: %v", buf[:n], client.RemoteAddr(), err)
+		}
+	}
+}
+
+// notifyHost reports the client ready, with pid as the main pid of the
+// service. Without a recorded pid, systemd keeps watching rmica itself.
+func notifyHost(client *net.UnixConn, pid int) error {
+	msg := "READY=1\n"
+	if pid != 0 {
+		msg += "MAINPID=" + strconv.Itoa(pid) + "\n"
+	}
+	_, err := client.Write([]byte(msg))
+	return err
+}
+
 // ==================== Verification Utilities ====================
 
 func verifyContainerDir(container *Container) {
 	root := container.Root()
 	id := container.Id()
 	statePath := filepath.Join(container.StateDir(), defs.StateFilename)
-	stateFile, err := os.OpenFile(statePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
-	if err != nil {
+	if _, err := os.Stat(statePath); err != nil {
 		logger.Errorf(
-			"[rmica] verifyContainerDir: failed to open state file %s id=%s: %v", 
+			"[rmica] verifyContainerDir: failed to stat state file %s id=%s: %v", 
 			statePath, id, err)
 	}
 	logger.Debugf("[rmica] travelly called for id=%s", id)
 	logger.Debugf("[rmica] root=%s, state.json=%s", root, statePath)
 	logger.Fprintf("travelly called for id=%s", id)
 	logger.Fprintf("root=%s, state.json=%s", root, statePath)
-	defer stateFile.Close()
 	// recursively travel root dir and print like tree:
 	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -592,6 +998,8 @@ func StartContainer(context *cli.Context, action defs.CtAct, criuOpts *libcontai
 	if err := utils.RevisePidFile(context); err != nil {
 		return -1, err
 	}
+	// validates the whole spec, ParseAnnotations below stops at the first
+	// problem
 	spec, err := utils.SetupSpec(context)
 	if err != nil {
 		return -2, fmt.Errorf("failed to load spec: %w", err)
@@ -606,6 +1014,11 @@ func StartContainer(context *cli.Context, action defs.CtAct, criuOpts *libcontai
 	// 而这个通信同步管理，混部的runtime部分需要承担多少? ——哪些events需要通知？
 	// NOTICE: 整合后，runtime部分就属于 mica daemon, 所以是同一个进程；(****)
 	// rmica 本身会 wait for "ready" 或其他, 
+	createMsg, err := communication.ParseAnnotations(spec.Annotations, cntrId, utils.BundleRootfs(spec))
+	if err != nil {
+		return -2, fmt.Errorf("failed to build client config from annotations: %w", err)
+	}
+
 	notifySocket := newNotifySocket(context, os.Getenv("NOTIFY_SOCKET"), cntrId)
 	if notifySocket != nil {
 		// update ENV and Mount information to spec
@@ -644,8 +1057,8 @@ func StartContainer(context *cli.Context, action defs.CtAct, criuOpts *libcontai
 	}
 
 	ct := &mcs.ClientTask{
-		Terminal: false,
-		Name: "test",
+		Terminal: spec.Process != nil && spec.Process.Terminal,
+		Name: createMsg.ClientName(),
 		Tty: "/dev/micatty",
 	}
 
@@ -657,18 +1070,14 @@ func StartContainer(context *cli.Context, action defs.CtAct, criuOpts *libcontai
 		container: cntr,
 		action: action,
 		notifySocket: notifySocket,
+		consoleSocket: context.String("console-socket"),
 		criuOpts: criuOpts,
+		createMsg: createMsg,
 	}
 
 	logger.Fprintf("runner = %v", r)
 	logger.Debugf("runner = %v", r)
 
-	if pidFile := context.String("pid-file"); pidFile != "" {
-		if err := utils.CreatePidFile(pidFile, os.Getpid()); err != nil {
-			return -7, fmt.Errorf("failed to create pid file: %w", err)
-		}
-	}
-
 	return r.runTask(ct)
 }
 
@@ -694,22 +1103,56 @@ func (r *runner) runTask(taskConfig *mcs.ClientTask) (int, error) {
 	
 	var caller = func() error {return nil}
 	callerName := ""
+	// state the container rests in once caller succeeded
+	var next ContainerState
 	switch r.action {
 	case defs.CT_ACT_RUN:
 		caller = r.container.Run
 		callerName = "Run"
+		next = &RunningState{c: r.container}
 	case defs.CT_ACT_CREATE:
-		caller = r.container.Start
-		callerName = "Start"
+		caller = r.container.Prepare
+		callerName = "Prepare"
+		next = &CreatedState{c: r.container}
 	case defs.CT_ACT_RESTORE:
-		caller = r.container.Restore
+		caller = func() error { return r.container.Restore(r.criuOpts) }
 		callerName = "Restore"
+		next = &RestoredState{c: r.container}
 	}
 	
+	if r.action != defs.CT_ACT_RESTORE {
+		if err = r.container.Register(r.createMsg); err != nil {
+			return -1, err
+		}
+		if err = r.container.runCreateHooks(); err != nil {
+			return -1, err
+		}
+	}
+
 	logger.Fprintf("caller = %v, action = %s", caller, callerName)
 	err = caller()
 	logger.Fprintf("caller = %v", caller)
+	if err == nil {
+		err = r.setupIO(taskConfig)
+	}
+	if err == nil && next != nil {
+		err = r.container.setState(next)
+	}
+	if err == nil {
+		err = r.container.startMonitor()
+	}
+	if err == nil && r.pidFile != "" {
+		if err = utils.CreatePidFile(r.pidFile, r.container.State().Pid); err != nil {
+			err = fmt.Errorf("failed to create pid file: %w", err)
+		}
+	}
 	verifyContainerDir(r.container)
+	if err == nil && r.action == defs.CT_ACT_RUN && r.notifySocket != nil && !r.detach {
+		// systemd runs the service in the foreground
+		if werr := r.notifySocket.WaitForContainer(r.container); werr != nil {
+			return -1, werr
+		}
+	}
 
 	return 0, err
 }
@@ -722,17 +1165,28 @@ func (r *runner) destroy() {
 	}
 }
 
-// TODO: mica console 
+// setupIO connects the client console to the container engine: through a
+// pty handed to the console socket, or through the stdio rmica inherited.
+func (r *runner) setupIO(taskConfig *mcs.ClientTask) error {
+	if taskConfig.Terminal {
+		if r.consoleSocket == "" {
+			logger.Debugf("no console socket, the console of %s stays unattached", r.container.Id())
+			return nil
+		}
+		return r.setupConsole()
+	}
+	return r.container.startProxy(nil)
+}
+
+// checkTerminal follows runc: a detached container with a terminal needs a
+// console socket to hand the pty to, and the socket needs both.
 func (r *runner) checkTerminal(taskConfig *mcs.ClientTask) error {
 	detach := r.detach || (r.action == defs.CT_ACT_CREATE)
   if detach && taskConfig.Terminal && r.consoleSocket == "" {
 		return errors.New("cannot allocate tty if rmica will detach without setting a console socket")
 	}
 	if (!detach || !taskConfig.Terminal) && r.consoleSocket != "" {
-		return errors.New("cannot allocate tty if rmica will not detach")
-	}
-	if taskConfig.Terminal && r.consoleSocket != "" {
-		return errors.New("cannot allocate tty if rmica will not detach or allocate tty")
+		return errors.New("cannot use console socket if rmica will not detach or allocate tty")
 	}
 	return nil
 }
@@ -746,24 +1200,134 @@ func newTaskFromConfig(taskConfig *mcs.ClientTask) (*mcs.ClientTask, error) {
 // ==================== Container Utilities ====================
 
 func createContainer(context *cli.Context, id string, spec *specs.Spec) (*Container, error) {
-	return Create(context.GlobalString("root"), id, spec)
+	micaDir, err := utils.MicaSocketDir(context, spec.Annotations, "")
+	if err != nil {
+		return nil, err
+	}
+	// SetupSpec changed into the bundle
+	bundle, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	container, err := Create(context.GlobalString("root"), id, bundle, spec, micaDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := container.applyGlobalFlags(context); err != nil {
+		os.RemoveAll(container.StateDir())
+		return nil, err
+	}
+	return container, nil
+}
+
+// applyGlobalFlags applies --mica-timeout and --mica-protocol to the micad
+// client of c, and keeps the global flags for the rmica processes c spawns.
+func (c *Container) applyGlobalFlags(context *cli.Context) error {
+	c.globalArgs = globalArgs(context)
+	c.setMicadTimeout(context.GlobalDuration("mica-timeout"))
+	if name := context.GlobalString("mica-protocol"); name != "" {
+		p, err := communication.ParseProtocol(name)
+		if err != nil {
+			return err
+		}
+		c.setMicadProtocol(p)
+	}
+	return nil
+}
+
+// globalArgs returns the global flags of context other than --root, as
+// the command line of a child rmica takes them.
+func globalArgs(context *cli.Context) []string {
+	var args []string
+	for _, name := range []string{"mica-socket", "mica-dir", "mica-protocol", "log", "log-format"} {
+		if context.GlobalIsSet(name) {
+			args = append(args, "--"+name, context.GlobalString(name))
+		}
+	}
+	if context.GlobalIsSet("mica-timeout") {
+		args = append(args, "--mica-timeout", context.GlobalDuration("mica-timeout").String())
+	}
+	if context.GlobalBool("debug") {
+		args = append(args, "--debug")
+	}
+	return args
+}
+
+// command returns `rmica <global flags> args` to run in a session of its
+// own, so that it finds the same micad and logs the same way this rmica
+// does, and outlives it.
+func (c *Container) command(args ...string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	argv := append([]string{"--root", c.root}, c.globalArgs...)
+	cmd := exec.Command(self, append(argv, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return cmd, nil
 }
 
-func Load(root, id string) (*Container, error) {
+// setMicadTimeout bounds every micad operation by timeout instead of the
+// per operation defaults, 0 keeps the defaults.
+func (c *Container) setMicadTimeout(timeout time.Duration) {
+	if s, ok := c.micad.(*communication.SocketClient); ok {
+		s.Timeout = timeout
+	}
+}
+
+func (c *Container) setMicadProtocol(p communication.Protocol) {
+	if s, ok := c.micad.(*communication.SocketClient); ok {
+		s.Protocol = p
+	}
+}
+
+// Load reads the container id from root. socket carries the command line
+// overrides of the micad location, the rest of it is filled in from the
+// container state.
+func Load(root, id string, socket communication.SocketSource) (*Container, error) {
 	containerDir := filepath.Join(root, id)
 	if _, err := os.Stat(containerDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("container %s not found: %w", id, utils.ErrNotExist)
+		}
 		return nil, fmt.Errorf("container %s not found: %w", id, err)
 	}
 
-	return &Container{
-		id:   id,
-		root: root,
-		cstate: &StoppedState{},
-	}, nil
+	rec, err := readStateRecord(containerDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state of container %s: %w", id, err)
+	}
+
+	socket.Recorded = rec.MicaDir
+	if rec.Config != nil {
+		socket.Annotations = rec.Config.Annotations
+	}
+	micaDir, err := communication.ResolveSocketDir(socket)
+	if err != nil {
+		return nil, err
+	}
+
+	cntr := &Container{
+		id:         id,
+		root:       root,
+		bundle:     rec.Bundle,
+		config:     rec.Config,
+		initPid:    rec.Pid,
+		created:    rec.Created,
+		clientName: rec.ClientName,
+		micaDir:    micaDir,
+		micad:      communication.NewSocketClient(micaDir),
+		exit:       rec.Exit,
+		updating:   rec.Updating,
+	}
+	cntr.cstate = cntr.stateFromStatus(rec.Status)
+	logger.Debugf("loaded container %s (state.json v%d): %s", id, rec.RecordVersion, rec.Status)
+	return cntr, nil
 }
 
 // NOTICE We create state dir in host for container engine
-func Create(root, id string, config *specs.Spec) (*Container, error) {
+// config comes from utils.LoadSpec, which validated it already.
+func Create(root, id, bundle string, config *specs.Spec, micaDir string) (*Container, error) {
 	if root == "" {
 		return nil, errors.New("root is empty")
 	}
@@ -772,12 +1336,8 @@ func Create(root, id string, config *specs.Spec) (*Container, error) {
 		return nil, err
 	}
 
-	if err := utils.ValidateSpec(config); err != nil {
-		return nil, err
-	}
-
-	if err := utils.ValidateTaskSpec(config); err != nil {
-		return nil, err
+	if config == nil {
+		return nil, errors.New("config cannot be null")
 	}
 
 
@@ -814,9 +1374,17 @@ func Create(root, id string, config *specs.Spec) (*Container, error) {
 	cntr := &Container{
 		id: id,
 		root: root,
+		bundle: bundle,
 		config: config,
+		created: time.Now().UTC(),
+		micaDir: micaDir,
+		micad: communication.NewSocketClient(micaDir),
 	}
 	cntr.cstate = &StoppedState{c: cntr}
+	if _, err := cntr.updateState(nil); err != nil {
+		os.RemoveAll(stateDir)
+		return nil, fmt.Errorf("failed to save state of container %s: %w", id, err)
+	}
 	logger.Fprintf("container %v created", cntr)
 	logger.Debugf("container %v created", cntr)
 	return cntr, nil
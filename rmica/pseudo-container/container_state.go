@@ -2,13 +2,15 @@
 package pseudo_container
 
 import (
+	"context"
 	"fmt"
 	"os"
 
-	"rmica/communication"
 	"rmica/logger"
+	"rmica/monitor"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
 )
 
 // ==================== Type Definitions ====================
@@ -80,13 +82,8 @@ func (r *RunningState) status() specs.ContainerState {
 
 func (r *RunningState) transition(to ContainerState) error {
 	switch to.(type) {
-	case *StoppedState:
-		if r.c.hasInit() {
-			return fmt.Errorf("container is running")
-		}
-		r.c.cstate = to
-		return nil
-	case *PausedState:
+	case *StoppedState, *PausedState:
+		// micad tells when the client stopped, the monitor follows it
 		r.c.cstate = to
 		return nil
 	case *RunningState:
@@ -96,19 +93,20 @@ func (r *RunningState) transition(to ContainerState) error {
 }
 
 func (r *RunningState) destroy() error {
-	if r.c.hasInit() {
-		return fmt.Errorf("container is running")
-	}
 	return destroy(r.c)
 }
 
+// StatePaused is what runc writes to state.json for a paused container,
+// specs-go has no such status.
+const StatePaused specs.ContainerState = "paused"
+
 // Not defined in spec-go but in runc libcontainer
 type PausedState struct {
 	c *Container
 }
 
 func (p *PausedState) status() specs.ContainerState {
-	return specs.StateRunning // Using Running state as Paused is not in specs-go
+	return StatePaused
 }
 
 func (p *PausedState) transition(to ContainerState) error {
@@ -116,16 +114,12 @@ func (p *PausedState) transition(to ContainerState) error {
 	case *RunningState, *StoppedState:
 		p.c.cstate = to
 		return nil
-	case *PausedState:
-		return nil
 	}
+	// a paused client cannot be paused again
 	return newStateTransitionError(p, to)
 }
 
 func (p *PausedState) destroy() error {
-	if p.c.hasInit() {
-		return fmt.Errorf("container is paused")
-	}
 	return destroy(p.c)
 }
 
@@ -165,7 +159,10 @@ func (r *RestoredState) status() specs.ContainerState {
 
 func (r *RestoredState) transition(to ContainerState) error {
 	switch to.(type) {
-	case *StoppedState, *RunningState:
+	case *StoppedState, *RunningState, *PausedState:
+		r.c.cstate = to
+		return nil
+	case *RestoredState:
 		return nil
 	}
 	return newStateTransitionError(r, to)
@@ -177,17 +174,25 @@ func (r *RestoredState) destroy() error {
 
 // ==================== Helper Functions ====================
 
+// killed reports whether the client stopped through SIGKILL, which stops
+// and removes it.
+func (c *Container) killed() bool {
+	return c.exit != nil && c.exit.Status == monitor.KilledStatus(unix.SIGKILL)
+}
+
 // Helper function to destroy container resources
 // 1. remove host container dir
 // 2. remove the task or shut down the clientOS
 // TODO: we have to wrap task config into container spec files 
 func destroy(c *Container) error {
-	// BUG: container informatino and task config is separated, here is just for demo
-	res := communication.Send2micaCmd("rm", c.Id())
-	if res != "" {
-		// TODO: handle response
-		logger.Debugf("destroy container %s: %s", c.Id(), res)
-		logger.Fprintf("destroy container %s: %s", c.Id(), res)
+	// no client name means micad never created a client for us, and kill
+	// with SIGKILL removed it already
+	if c.clientName != "" && !c.killed() {
+		if _, err := c.micad.Remove(context.Background(), c.clientName); err != nil {
+			// the client may be gone already, the state dir must go anyway
+			logger.Debugf("destroy container %s: %v", c.Id(), err)
+			logger.Fprintf("destroy container %s: %v", c.Id(), err)
+		}
 	}
 
 	// Remove container directory
@@ -195,19 +200,7 @@ func destroy(c *Container) error {
 		return fmt.Errorf("failed to remove container directory: %w", err)
 	}
 
-	if c.config.Hooks != nil {
-		s := c.OCIState()
-		logger.Fprintf("get OCI state: %s [%s]", s.Status, s.Bundle)
-		logger.Fprintf("we do not run hook in demo")
-		s.Status = specs.StateStopped
-	}
 	c.cstate = &StoppedState{c: c}
+	c.warnHooks(HookPoststop, specs.StateStopped)
 	return nil
 }
-
-
-func runHook(hooks *specs.Hooks, name string, state *specs.State) error {
-	logger.Fprintf("run hook %s: %s", name, state)
-	logger.Debugf("run hook %s: %s", name, state)
-	return nil
-}
\ No newline at end of file
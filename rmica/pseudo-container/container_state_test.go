@@ -0,0 +1,95 @@
+package pseudo_container
+
+import (
+	"errors"
+	"testing"
+
+	"rmica/communication"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestStateTransitions(t *testing.T) {
+	c := &Container{id: "zephyr01"}
+	states := map[string]ContainerState{
+		"created":  &CreatedState{c: c},
+		"running":  &RunningState{c: c},
+		"paused":   &PausedState{c: c},
+		"stopped":  &StoppedState{c: c},
+		"restored": &RestoredState{c: c},
+	}
+	valid := map[string][]string{
+		"created":  {"created", "running", "paused", "stopped"},
+		"running":  {"running", "paused", "stopped"},
+		"paused":   {"running", "stopped"},
+		"stopped":  {"stopped", "running", "restored"},
+		"restored": {"restored", "running", "paused", "stopped"},
+	}
+	for from, fromState := range states {
+		for to, toState := range states {
+			ok := false
+			for _, v := range valid[from] {
+				ok = ok || v == to
+			}
+			c.cstate = fromState
+			err := fromState.transition(toState)
+			if ok {
+				if err != nil {
+					t.Errorf("%s -> %s: %v", from, to, err)
+				}
+				// moving to the state the container is in is a no-op
+				if from != to && c.cstate != toState {
+					t.Errorf("%s -> %s left the container in %s", from, to, c.cstate.status())
+				}
+				continue
+			}
+			var stErr *StateTransitionError
+			if !errors.As(err, &stErr) {
+				t.Errorf("%s -> %s: got %v, want a *StateTransitionError", from, to, err)
+				continue
+			}
+			if stErr.From != fromState.status() || stErr.To != toState.status() {
+				t.Errorf("%s -> %s: error says %s -> %s", from, to, stErr.From, stErr.To)
+			}
+			if c.cstate != fromState {
+				t.Errorf("%s -> %s failed but moved the container to %s", from, to, c.cstate.status())
+			}
+		}
+	}
+}
+
+// Pause and resume of a container in the wrong state fail before micad is
+// asked.
+func TestInvalidPauseResume(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		from specs.ContainerState
+		do   func(*Container) error
+		to   specs.ContainerState
+	}{
+		{"pause stopped", specs.StateStopped, (*Container).Pause, StatePaused},
+		{"pause paused", StatePaused, (*Container).Pause, StatePaused},
+		{"resume running", specs.StateRunning, (*Container).Resume, specs.StateRunning},
+		{"resume stopped", specs.StateStopped, (*Container).Resume, specs.StateRunning},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := communication.NewFakeClient()
+			c := newFakeContainer(t, fake, tc.from)
+			fake.Calls = nil
+			err := tc.do(c)
+			var stErr *StateTransitionError
+			if !errors.As(err, &stErr) {
+				t.Fatalf("got %v, want a *StateTransitionError", err)
+			}
+			if stErr.From != tc.from || stErr.To != tc.to {
+				t.Errorf("error says %s -> %s, want %s -> %s", stErr.From, stErr.To, tc.from, tc.to)
+			}
+			if len(fake.Calls) != 0 {
+				t.Errorf("micad got %q", fake.Calls)
+			}
+			if c.Status() != tc.from {
+				t.Errorf("container is %s, want %s", c.Status(), tc.from)
+			}
+		})
+	}
+}
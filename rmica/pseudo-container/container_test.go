@@ -0,0 +1,262 @@
+package pseudo_container
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"rmica/communication"
+	"rmica/mcs"
+	"rmica/monitor"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// newFakeContainer returns container zephyr01, in the state status, whose
+// client zephyr01 is known to fake in the matching micad state.
+func newFakeContainer(t *testing.T, fake *communication.FakeClient, status specs.ContainerState) *Container {
+	t.Helper()
+	c := &Container{
+		id:         "zephyr01",
+		root:       t.TempDir(),
+		bundle:     "/var/lib/rmica/bundles/zephyr01",
+		config:     &specs.Spec{Version: specs.Version},
+		clientName: "zephyr01",
+		micad:      fake,
+	}
+	client := communication.ClientRunning
+	switch status {
+	case specs.StateCreated:
+		c.cstate = &CreatedState{c: c}
+		client = communication.ClientOffline
+	case specs.StateRunning:
+		c.cstate = &RunningState{c: c}
+	case StatePaused:
+		c.cstate = &PausedState{c: c}
+		client = communication.ClientSuspended
+	case specs.StateStopped:
+		c.cstate = &StoppedState{c: c}
+		client = communication.ClientOffline
+	default:
+		t.Fatalf("no fake container in state %s", status)
+	}
+	fake.Known["zephyr01"] = &communication.ClientStatus{Name: "zephyr01", CPU: 3, State: client}
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.updateState(nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// onDisk reads back the status and exit Load would see.
+func onDisk(t *testing.T, c *Container) (specs.ContainerState, *monitor.Exit) {
+	t.Helper()
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec.Status, rec.Exit
+}
+
+func TestSignal(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		from   specs.ContainerState
+		sig    unix.Signal
+		calls  []string
+		status specs.ContainerState
+		exit   *monitor.Exit
+		client communication.ClientState
+		gone   bool
+	}{
+		{
+			name:   "SIGTERM stops",
+			from:   specs.StateRunning,
+			sig:    unix.SIGTERM,
+			calls:  []string{"stop zephyr01"},
+			status: specs.StateStopped,
+			exit:   &monitor.Exit{Status: 128 + 15},
+			client: communication.ClientOffline,
+		},
+		{
+			name:   "SIGKILL stops and removes",
+			from:   specs.StateRunning,
+			sig:    unix.SIGKILL,
+			calls:  []string{"stop zephyr01", "rm zephyr01"},
+			status: specs.StateStopped,
+			exit:   &monitor.Exit{Status: 128 + 9},
+			gone:   true,
+		},
+		{
+			name:   "SIGSTOP pauses",
+			from:   specs.StateRunning,
+			sig:    unix.SIGSTOP,
+			calls:  []string{"pause zephyr01"},
+			status: StatePaused,
+			client: communication.ClientSuspended,
+		},
+		{
+			name:   "SIGCONT resumes",
+			from:   StatePaused,
+			sig:    unix.SIGCONT,
+			calls:  []string{"resume zephyr01"},
+			status: specs.StateRunning,
+			client: communication.ClientRunning,
+		},
+		{
+			name:   "signal 0 probes",
+			from:   specs.StateRunning,
+			sig:    0,
+			calls:  []string{"status zephyr01"},
+			status: specs.StateRunning,
+			client: communication.ClientRunning,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := communication.NewFakeClient()
+			c := newFakeContainer(t, fake, tc.from)
+			fake.Calls = nil
+			if err := c.Signal(tc.sig, mcs.ClientTask{Name: "zephyr01"}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fake.Calls, tc.calls) {
+				t.Errorf("micad got %q, want %q", fake.Calls, tc.calls)
+			}
+			status, exit := onDisk(t, c)
+			if status != tc.status {
+				t.Errorf("state.json says %s, want %s", status, tc.status)
+			}
+			if (exit == nil) != (tc.exit == nil) || exit != nil && exit.Status != tc.exit.Status {
+				t.Errorf("exit %+v, want %+v", exit, tc.exit)
+			}
+			cs, ok := fake.Known["zephyr01"]
+			if tc.gone {
+				if ok {
+					t.Errorf("client still known as %s", cs.State)
+				}
+			} else if cs.State != tc.client {
+				t.Errorf("client is %s, want %s", cs.State, tc.client)
+			}
+		})
+	}
+}
+
+// A stopped container only passes a signal on if micad still has its
+// client.
+func TestSignalStopped(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateStopped)
+	delete(fake.Known, "zephyr01")
+	fake.Calls = nil
+	err := c.Signal(unix.SIGTERM, mcs.ClientTask{Name: "zephyr01"})
+	if !errors.Is(err, utils.ErrNotRunning) {
+		t.Fatalf("got %v, want ErrNotRunning", err)
+	}
+	if want := []string{"status zephyr01"}; !reflect.DeepEqual(fake.Calls, want) {
+		t.Errorf("micad got %q, want %q", fake.Calls, want)
+	}
+}
+
+// A failed pause or resume leaves the container where it was, in memory
+// and on disk.
+func TestPauseResumeFailure(t *testing.T) {
+	for _, tc := range []struct {
+		from specs.ContainerState
+		op   string
+		do   func(*Container) error
+	}{
+		{specs.StateRunning, "pause", (*Container).Pause},
+		{StatePaused, "resume", (*Container).Resume},
+	} {
+		t.Run(tc.op, func(t *testing.T) {
+			fake := communication.NewFakeClient()
+			c := newFakeContainer(t, fake, tc.from)
+			failed := &communication.FailedError{Op: tc.op, Client: "zephyr01", Diag: "rproc busy"}
+			fake.Errs[tc.op] = failed
+			if err := tc.do(c); !errors.Is(err, failed) {
+				t.Fatalf("got %v, want %v", err, failed)
+			}
+			if c.Status() != tc.from {
+				t.Errorf("container is %s, want %s", c.Status(), tc.from)
+			}
+			if status, _ := onDisk(t, c); status != tc.from {
+				t.Errorf("state.json says %s, want %s", status, tc.from)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	c.clientName = ""
+	delete(fake.Known, "zephyr01")
+
+	msg := &communication.CreateMsg{CPU: 2}
+	copy(msg.Name[:], "rtos-a")
+	if err := c.Register(msg); err != nil {
+		t.Fatal(err)
+	}
+	if cs := fake.Known["rtos-a"]; cs == nil || cs.CPU != 2 {
+		t.Fatalf("micad has %+v, want rtos-a on cpu 2", cs)
+	}
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ClientName != "rtos-a" {
+		t.Errorf("state.json names client %q, want rtos-a", rec.ClientName)
+	}
+
+	// a second create of the same client fails and keeps the name
+	if err := c.Register(msg); err == nil {
+		t.Error("second create succeeded")
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		exit  *monitor.Exit
+		calls []string
+	}{
+		{"after SIGTERM", monitor.NewExit(monitor.KilledStatus(unix.SIGTERM)), []string{"rm zephyr01"}},
+		// SIGKILL removed the client already
+		{"after SIGKILL", monitor.NewExit(monitor.KilledStatus(unix.SIGKILL)), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := communication.NewFakeClient()
+			c := newFakeContainer(t, fake, specs.StateStopped)
+			c.exit = tc.exit
+			fake.Calls = nil
+			if err := c.Destroy(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fake.Calls, tc.calls) {
+				t.Errorf("micad got %q, want %q", fake.Calls, tc.calls)
+			}
+			if _, err := os.Stat(c.StateDir()); !os.IsNotExist(err) {
+				t.Errorf("state dir still there: %v", err)
+			}
+		})
+	}
+}
+
+// Destroy removes the state dir even if micad lost the client.
+func TestDestroyUnknownClient(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateStopped)
+	delete(fake.Known, "zephyr01")
+	if err := c.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(c.root, "zephyr01")); !os.IsNotExist(err) {
+		t.Errorf("state dir still there: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package pseudo_container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"rmica/communication"
+	"rmica/defs"
+	"rmica/logger"
+	"rmica/monitor"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ExecProcess is a task `rmica exec` started on the client, as recorded in
+// <state dir>/execs/<task id>.json.
+type ExecProcess struct {
+	TaskID  uint32         `json:"taskId"`
+	Process *specs.Process `json:"process"`
+	Created time.Time      `json:"created"`
+	// Exit is set once the task ended
+	Exit *monitor.Exit `json:"exit,omitempty"`
+}
+
+// newExecTask keeps what the client can run of an OCI process.
+func newExecTask(p *specs.Process) (*communication.ExecTask, error) {
+	if p == nil || len(p.Args) == 0 {
+		return nil, errors.New("exec args cannot be empty")
+	}
+	if p.Cwd != "" && !filepath.IsAbs(p.Cwd) {
+		return nil, fmt.Errorf("cwd %q must be an absolute path", p.Cwd)
+	}
+	return &communication.ExecTask{
+		Args:     p.Args,
+		Env:      p.Env,
+		Cwd:      p.Cwd,
+		Terminal: p.Terminal,
+	}, nil
+}
+
+// ExecTask starts process as a new task on the running client and records
+// it in the state dir.
+func (c *Container) ExecTask(process *specs.Process) (*ExecProcess, error) {
+	task, err := newExecTask(process)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	switch c.cstate.status() {
+	case specs.StateRunning:
+	case StatePaused:
+		return nil, fmt.Errorf("cannot exec in container %s: %w", c.id, utils.ErrPaused)
+	default:
+		return nil, fmt.Errorf("cannot exec in container %s: %w", c.id, utils.ErrNotRunning)
+	}
+
+	logger.Infof("[container] exec %v on client %s for id=%s", task.Args, c.client(), c.id)
+	id, err := c.micad.Exec(context.Background(), c.client(), task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec on client %s: %w", c.client(), err)
+	}
+	p := &ExecProcess{TaskID: id, Process: process, Created: time.Now()}
+	if err := c.saveExec(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WaitExec blocks until the task of p ends, polling micad every interval,
+// and records its exit. A client that went away takes its tasks with it,
+// they exit with monitor.ExitStatusCrashed.
+func (c *Container) WaitExec(ctx context.Context, p *ExecProcess, interval time.Duration) (*monitor.Exit, error) {
+	if p.Exit != nil {
+		return p.Exit, nil
+	}
+	if interval <= 0 {
+		interval = monitor.DefaultInterval
+	}
+	client := c.ClientName()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		st, err := c.micad.ExecStatus(ctx, client, p.TaskID)
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case errors.Is(err, communication.ErrConnRefused):
+			logger.Debugf("[container] client %s is gone, so is task %d", client, p.TaskID)
+			p.Exit = monitor.NewExit(monitor.ExitStatusCrashed)
+		case err != nil:
+			logger.Warnf("[container] status of task %d on client %s: %v", p.TaskID, client, err)
+		case st.Exited:
+			p.Exit = monitor.NewExit(st.ExitCode)
+		}
+		if p.Exit != nil {
+			c.m.Lock()
+			defer c.m.Unlock()
+			if err := c.saveExec(p); err != nil {
+				return nil, err
+			}
+			return p.Exit, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExecProcesses lists the tasks `rmica exec` started on the client.
+func (c *Container) ExecProcesses() ([]*ExecProcess, error) {
+	dir := filepath.Join(c.StateDir(), defs.ExecsDirname)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var procs []*ExecProcess
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p := &ExecProcess{}
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("failed to decode exec %s: %w", entry.Name(), err)
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// saveExec writes the record of p, c.m must be held.
+func (c *Container) saveExec(p *ExecProcess) (retErr error) {
+	dir := filepath.Join(c.StateDir(), defs.ExecsDirname)
+	if err := os.MkdirAll(dir, defs.ContainerDirPerm); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(dir, "exec-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+	if err := utils.WriteJSON(tmpFile, p); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	name := strconv.FormatUint(uint64(p.TaskID), 10) + ".json"
+	return os.Rename(tmpFile.Name(), filepath.Join(dir, name))
+}
@@ -0,0 +1,185 @@
+package pseudo_container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"rmica/defs"
+	"rmica/logger"
+	"rmica/monitor"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// how long start waits for the monitor to take its end of exec.fifo
+var execFifoTimeout = 5 * time.Second
+
+func (c *Container) execFifoPath() string {
+	return filepath.Join(c.StateDir(), defs.ExecFifoFilename)
+}
+
+// Prepare leaves a client micad created loaded but not booted, the way runc
+// leaves the init of a created container: it creates exec.fifo, the monitor
+// blocks on it until start opens it.
+func (c *Container) Prepare() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	logger.Debugf("createExecFifo for id=%s", c.id)
+	if err := c.createExecFifo(defs.ExecFifoFilename); err != nil {
+		return fmt.Errorf("failed to create exec fifo: %w", err)
+	}
+	return nil
+}
+
+// startMonitor spawns `rmica monitor` in a session of its own and records
+// it as the pid of the container. It outlives create, run and restore like
+// the console proxy does, and lives as long as the client does.
+func (c *Container) startMonitor() error {
+	cmd, err := c.monitorCommand()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start monitor of container %s: %w", c.id, err)
+	}
+	logger.Debugf("monitor of container %s is pid %d", c.id, cmd.Process.Pid)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.initPid = cmd.Process.Pid
+	if _, err := c.updateState(nil); err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// monitorCommand returns the `rmica monitor` startMonitor spawns.
+func (c *Container) monitorCommand() (*exec.Cmd, error) {
+	return c.command("monitor", c.id)
+}
+
+// Monitor waits for the client to exit and records its exit. For a created
+// container, it first holds the write end of exec.fifo until start opens it,
+// and returns early if the container is deleted or killed before it was
+// started.
+func (c *Container) Monitor(interval time.Duration) error {
+	if interval <= 0 {
+		interval = monitor.DefaultInterval
+	}
+	if c.Status() != specs.StateCreated {
+		return c.monitorClient(interval)
+	}
+	opened := make(chan error, 1)
+	go func() {
+		// blocks until start opens the read end
+		f, err := os.OpenFile(c.execFifoPath(), os.O_WRONLY, 0)
+		if err != nil {
+			opened <- err
+			return
+		}
+		_, err = f.Write([]byte("0"))
+		f.Close()
+		opened <- err
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-opened:
+			if errors.Is(err, os.ErrNotExist) {
+				logger.Debugf("container %s was deleted before it was started, monitor leaves", c.id)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("exec fifo of container %s: %w", c.id, err)
+			}
+			return c.monitorClient(interval)
+		case <-ticker.C:
+			if c.stoppedOnDisk() {
+				logger.Debugf("container %s stopped before it was started, monitor leaves", c.id)
+				return nil
+			}
+		}
+	}
+}
+
+func (c *Container) monitorClient(interval time.Duration) error {
+	if err := c.refresh(); err != nil {
+		return err
+	}
+	c.m.Lock()
+	// create may not have recorded it yet
+	c.initPid = os.Getpid()
+	c.m.Unlock()
+	exit, err := c.Wait(context.Background(), interval)
+	if errors.Is(err, utils.ErrNotExist) || errors.Is(err, os.ErrNotExist) {
+		logger.Debugf("container %s was deleted, monitor leaves", c.id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Infof("[rmica] client of %s exited with status %d", c.id, exit.Status)
+	return nil
+}
+
+// releaseExecFifo opens exec.fifo, which lets the monitor blocked on it go
+// on to wait for the client, and removes it so that start happens once.
+// Callers hold c.m.
+func (c *Container) releaseExecFifo() error {
+	path := c.execFifoPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// created by an rmica that booted the client in create already
+		logger.Debugf("container %s has no exec fifo", c.id)
+		return nil
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			read <- err
+			return
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err == nil && len(data) == 0 {
+			err = errors.New("monitor went away")
+		}
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			logger.Warnf("exec fifo of container %s: %v", c.id, err)
+		}
+	case <-time.After(execFifoTimeout):
+		// booting the client matters more than having its exit recorded,
+		// wait still does that
+		logger.Warnf("no monitor waits on the exec fifo of container %s", c.id)
+	}
+	return os.Remove(path)
+}
+
+// refresh takes over the status another rmica persisted meanwhile.
+func (c *Container) refresh() error {
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.cstate = c.stateFromStatus(rec.Status)
+	c.exit = rec.Exit
+	c.initPid = rec.Pid
+	return nil
+}
@@ -0,0 +1,270 @@
+package pseudo_container
+
+import (
+	"context"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/mcs"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+const testInterval = 10 * time.Millisecond
+
+// monitored prepares the exec fifo of a created container and runs the
+// monitor of it the way `rmica monitor` does, on a container of its own.
+// The monitor result arrives on the returned channel.
+func monitored(t *testing.T, fake *communication.FakeClient) (*Container, <-chan error) {
+	t.Helper()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	if err := c.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(c.root, c.id, communication.SocketSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.micad = fake
+	done := make(chan error, 1)
+	go func() { done <- m.Monitor(testInterval) }()
+	t.Cleanup(func() {
+		// let a monitor still blocked on the fifo go
+		if f, err := os.OpenFile(c.execFifoPath(), os.O_RDONLY|unix.O_NONBLOCK, 0); err == nil {
+			f.Close()
+		}
+	})
+	return c, done
+}
+
+func waitMonitor(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("monitor: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor did not leave")
+	}
+}
+
+// The monitor waits on exec.fifo until start, then follows the client until
+// it exits and records the exit.
+func TestMonitorWaitsForStart(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c, done := monitored(t, fake)
+
+	select {
+	case err := <-done:
+		t.Fatalf("monitor left before start: %v", err)
+	case <-time.After(10 * testInterval):
+	}
+
+	if err := c.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.execFifoPath()); !os.IsNotExist(err) {
+		t.Errorf("start left exec.fifo behind: %v", err)
+	}
+	if !fake.Known["zephyr01"].Running() {
+		t.Fatal("start did not boot the client")
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("monitor left while the client runs: %v", err)
+	case <-time.After(10 * testInterval):
+	}
+	if _, err := fake.Stop(context.Background(), "zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	waitMonitor(t, done)
+	status, exit := onDisk(t, c)
+	if status != specs.StateStopped || exit == nil || exit.Status != 0 {
+		t.Errorf("state.json says %s, exit %+v; want stopped with status 0", status, exit)
+	}
+}
+
+// A container deleted before start leaves no monitor behind.
+func TestMonitorDeleteBeforeStart(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	if err := c.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(c.root, c.id, communication.SocketSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.micad = fake
+	// delete got to exec.fifo first
+	if err := os.Remove(c.execFifoPath()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- m.Monitor(testInterval) }()
+	waitMonitor(t, done)
+}
+
+// A container deleted while its monitor waits on exec.fifo leaves no
+// monitor behind either.
+func TestMonitorDeleteWhileWaiting(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c, done := monitored(t, fake)
+	time.Sleep(5 * testInterval)
+	// the open of the removed fifo stays blocked, like in the monitor
+	// process that exits
+	if err := c.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	waitMonitor(t, done)
+}
+
+// A container killed before start leaves the monitor waiting on exec.fifo
+// as soon as the kill is on disk.
+func TestMonitorKillBeforeStart(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c, done := monitored(t, fake)
+	if err := c.Signal(unix.SIGKILL, mcs.ClientTask{Name: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+	waitMonitor(t, done)
+	if status, exit := onDisk(t, c); status != specs.StateStopped || exit == nil {
+		t.Errorf("state.json says %s, exit %+v", status, exit)
+	}
+}
+
+// Start boots the client even if no monitor takes exec.fifo.
+func TestStartWithoutMonitor(t *testing.T) {
+	old := execFifoTimeout
+	execFifoTimeout = 5 * testInterval
+	defer func() { execFifoTimeout = old }()
+
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	if err := c.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := c.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took < execFifoTimeout {
+		t.Errorf("start gave up on the monitor after %s", took)
+	}
+	if !fake.Known["zephyr01"].Running() {
+		t.Error("start did not boot the client")
+	}
+	if _, err := os.Stat(c.execFifoPath()); !os.IsNotExist(err) {
+		t.Errorf("start left exec.fifo behind: %v", err)
+	}
+	if status, _ := onDisk(t, c); status != specs.StateRunning {
+		t.Errorf("state.json says %s", status)
+	}
+}
+
+// globalFlags are the global flags of main.go that a child rmica needs.
+var globalFlags = []cli.Flag{
+	cli.StringFlag{Name: "root"},
+	cli.StringFlag{Name: "mica-socket"},
+	cli.StringFlag{Name: "mica-dir"},
+	cli.DurationFlag{Name: "mica-timeout"},
+	cli.StringFlag{Name: "mica-protocol"},
+	cli.BoolFlag{Name: "debug"},
+	cli.StringFlag{Name: "log"},
+	cli.StringFlag{Name: "log-format", Value: "text"},
+}
+
+// globalContext returns the context of a subcommand of `rmica args`.
+func globalContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+	app := cli.NewApp()
+	set := flag.NewFlagSet("rmica", flag.ContinueOnError)
+	for _, f := range globalFlags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	global := cli.NewContext(app, set, nil)
+	return cli.NewContext(app, flag.NewFlagSet("create", flag.ContinueOnError), global)
+}
+
+// Every global flag reaches the monitor and the console proxy, a monitor
+// without them looks for another micad than the rmica that spawned it.
+func TestChildCommandGlobalFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "defaults"},
+		{
+			name: "all",
+			args: []string{
+				"--root", "/ignored",
+				"--mica-socket", "/run/mica-test/mica-create.socket",
+				"--mica-dir", "/run/mica-test",
+				"--mica-timeout", "1m30s",
+				"--mica-protocol", "framed",
+				"--debug",
+				"--log", "/var/log/rmica.log",
+				"--log-format", "json",
+			},
+			want: []string{
+				"--mica-socket", "/run/mica-test/mica-create.socket",
+				"--mica-dir", "/run/mica-test",
+				"--mica-protocol", "framed",
+				"--log", "/var/log/rmica.log",
+				"--log-format", "json",
+				"--mica-timeout", "1m30s",
+				"--debug",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := communication.NewFakeClient()
+			c := newFakeContainer(t, fake, specs.StateCreated)
+			c.micad = communication.NewSocketClient(t.TempDir())
+			if err := c.applyGlobalFlags(globalContext(t, tc.args...)); err != nil {
+				t.Fatal(err)
+			}
+			global := append([]string{"--root", c.root}, tc.want...)
+
+			monitor, err := c.monitorCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(append([]string{}, global...), "monitor", "zephyr01"); !reflect.DeepEqual(monitor.Args[1:], want) {
+				t.Errorf("monitor argv %q, want %q", monitor.Args[1:], want)
+			}
+			proxy, err := c.proxyCommand(os.Stdin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(append([]string{}, global...), "proxy", "--tty", "zephyr01"); !reflect.DeepEqual(proxy.Args[1:], want) {
+				t.Errorf("proxy argv %q, want %q", proxy.Args[1:], want)
+			}
+
+			// the child reads the flags back the way they were given
+			child := globalContext(t, global...)
+			for _, f := range globalFlags {
+				name := f.GetName()
+				if name == "root" {
+					continue
+				}
+				parent := globalContext(t, tc.args...)
+				if got, want := child.GlobalGeneric(name), parent.GlobalGeneric(name); !reflect.DeepEqual(got, want) {
+					t.Errorf("child sees --%s %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package pseudo_container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"rmica/logger"
+	"rmica/monitor"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// OCI lifecycle hooks, in the order the spec runs them
+const (
+	HookPrestart        = "prestart"
+	HookCreateRuntime   = "createRuntime"
+	HookCreateContainer = "createContainer"
+	HookStartContainer  = "startContainer"
+	HookPoststart       = "poststart"
+	HookPoststop        = "poststop"
+)
+
+// SupportedHooks lists the hooks rmica runs.
+var SupportedHooks = []string{
+	HookPrestart,
+	HookCreateRuntime,
+	HookCreateContainer,
+	HookStartContainer,
+	HookPoststart,
+	HookPoststop,
+}
+
+func hooksByName(hooks *specs.Hooks, name string) []specs.Hook {
+	if hooks == nil {
+		return nil
+	}
+	switch name {
+	case HookPrestart:
+		return hooks.Prestart
+	case HookCreateRuntime:
+		return hooks.CreateRuntime
+	case HookCreateContainer:
+		return hooks.CreateContainer
+	case HookStartContainer:
+		return hooks.StartContainer
+	case HookPoststart:
+		return hooks.Poststart
+	case HookPoststop:
+		return hooks.Poststop
+	}
+	return nil
+}
+
+// runHooks runs the hooks of the container called name one after the other
+// with the OCI state of the container, in status, on their stdin. It stops
+// at the first hook that fails. Callers hold c.m.
+func (c *Container) runHooks(name string, status specs.ContainerState) error {
+	if c.config == nil {
+		return nil
+	}
+	hooks := hooksByName(c.config.Hooks, name)
+	if len(hooks) == 0 {
+		return nil
+	}
+	state := c.currentState()
+	state.Status = status
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	for i, h := range hooks {
+		logger.Debugf("running %s hook #%d of container %s: %s", name, i, c.id, h.Path)
+		if err := runHook(h, data); err != nil {
+			return fmt.Errorf("error running %s hook #%d: %w", name, i, err)
+		}
+	}
+	return nil
+}
+
+// runCreateHooks runs the hooks due once micad created the client: the
+// deprecated prestart, createRuntime and createContainer. The client runs
+// beside the host rather than in namespaces of its own, so the latter
+// runs in the namespaces of rmica like the others.
+func (c *Container) runCreateHooks() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.createHooks()
+}
+
+func (c *Container) createHooks() error {
+	for _, name := range []string{HookPrestart, HookCreateRuntime, HookCreateContainer} {
+		if err := c.runHooks(name, specs.StateCreating); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startContainerHooks runs the startContainer hooks right before micad
+// boots the client. If one fails, the client is not booted and the
+// container stops, as the spec wants.
+func (c *Container) startContainerHooks() error {
+	err := c.runHooks(HookStartContainer, specs.StateCreated)
+	if err == nil {
+		return nil
+	}
+	if serr := c.markStopped(monitor.NewExit(monitor.ExitStatusCrashed)); serr != nil {
+		logger.Warnf("container %s: %v", c.id, serr)
+	}
+	return err
+}
+
+// warnHooks runs hooks whose failure the spec only wants logged, poststart
+// and poststop.
+func (c *Container) warnHooks(name string, status specs.ContainerState) {
+	if err := c.runHooks(name, status); err != nil {
+		logger.Warnf("container %s: %v", c.id, err)
+	}
+}
+
+// runHook runs h with state on its stdin, killing it once its timeout
+// passed.
+func runHook(h specs.Hook, state []byte) error {
+	ctx := context.Background()
+	if h.Timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*h.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.Path)
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = h.Env
+	cmd.Stdin = bytes.NewReader(state)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %ds", h.Path, *h.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w, stdout: %s, stderr: %s", h.Path, err,
+			strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package pseudo_container
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rmica/communication"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// hookScript writes a shell hook running body to a temp dir.
+func hookScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHookGetsState(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	out := filepath.Join(t.TempDir(), "state")
+	c.config.Annotations = map[string]string{"org.example": "yes"}
+	c.config.Hooks = &specs.Hooks{
+		CreateRuntime: []specs.Hook{{
+			Path: hookScript(t, `cat > "$OUT"; echo "$0 $1" >> "$OUT.args"`),
+			Args: []string{"create-runtime", "first"},
+			Env:  []string{"OUT=" + out},
+		}},
+	}
+	if err := c.runCreateHooks(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state specs.State
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("hook got %q: %v", data, err)
+	}
+	if state.ID != "zephyr01" || state.Status != specs.StateCreating ||
+		state.Bundle != c.bundle || state.Annotations["org.example"] != "yes" {
+		t.Errorf("hook got %+v", state)
+	}
+	args, err := os.ReadFile(out + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(string(args)), " first") {
+		t.Errorf("hook got args %q", args)
+	}
+}
+
+func TestHookFailure(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	c.config.Hooks = &specs.Hooks{
+		Prestart: []specs.Hook{{Path: hookScript(t, "exit 0")}},
+		CreateContainer: []specs.Hook{
+			{Path: hookScript(t, "echo out; echo no mounts >&2; exit 3")},
+			{Path: hookScript(t, "touch "+filepath.Join(t.TempDir(), "ran"))},
+		},
+	}
+	err := c.runCreateHooks()
+	if err == nil {
+		t.Fatal("a failing hook went unnoticed")
+	}
+	for _, want := range []string{"createContainer hook #0", "exit status 3", "stdout: out", "stderr: no mounts"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	timeout := 1
+	h := specs.Hook{Path: hookScript(t, "exec sleep 10"), Timeout: &timeout}
+	start := time.Now()
+	err := runHook(h, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1s") {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("hook ran for %s", took)
+	}
+}
+
+// A failing startContainer hook keeps the client down and stops the
+// container.
+func TestStartContainerHookFailure(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	c.config.Hooks = &specs.Hooks{
+		StartContainer: []specs.Hook{{Path: hookScript(t, "exit 1")}},
+	}
+	fake.Calls = nil
+	if err := c.Start(); err == nil {
+		t.Fatal("start went on after the startContainer hook failed")
+	}
+	if len(fake.Calls) != 0 {
+		t.Errorf("micad got %q", fake.Calls)
+	}
+	status, exit := onDisk(t, c)
+	if status != specs.StateStopped || exit == nil {
+		t.Errorf("state.json says %s, exit %+v; want stopped with an exit", status, exit)
+	}
+}
+
+// Failing poststart and poststop hooks are only logged.
+func TestWarnHooks(t *testing.T) {
+	fake := communication.NewFakeClient()
+	c := newFakeContainer(t, fake, specs.StateCreated)
+	ran := filepath.Join(t.TempDir(), "ran")
+	c.config.Hooks = &specs.Hooks{
+		Poststart: []specs.Hook{{Path: hookScript(t, "exit 1")}},
+		Poststop:  []specs.Hook{{Path: hookScript(t, "touch "+ran+"; exit 1")}},
+	}
+	if err := c.Start(); err != nil {
+		t.Fatalf("a failing poststart hook failed start: %v", err)
+	}
+	c.cstate = &StoppedState{c: c}
+	if err := c.Destroy(); err != nil {
+		t.Fatalf("a failing poststop hook failed delete: %v", err)
+	}
+	if _, err := os.Stat(ran); err != nil {
+		t.Errorf("poststop hook did not run: %v", err)
+	}
+	if _, err := os.Stat(c.StateDir()); !os.IsNotExist(err) {
+		t.Errorf("state dir still there: %v", err)
+	}
+}
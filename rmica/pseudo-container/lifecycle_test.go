@@ -0,0 +1,111 @@
+package pseudo_container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/mcs"
+	"rmica/monitor"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func countCalls(calls []string, call string) int {
+	n := 0
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+// create, start, kill, wait and delete against micadtest, with the
+// monitor waiting for start and the exit on a container of its own.
+func TestLifecycle(t *testing.T) {
+	srv := newMicadServer(t, false)
+	c := newMicadContainer(t, srv, true)
+	done := waitInBackground(t, c)
+
+	if client, _ := srv.Client("zephyr01"); client.State != communication.ClientOffline {
+		t.Fatalf("create booted the client: %s", client.State)
+	}
+	if err := c.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if client, _ := srv.Client("zephyr01"); client.State != communication.ClientRunning {
+		t.Fatalf("start left the client %s", client.State)
+	}
+	if status, _ := onDisk(t, c); status != specs.StateRunning {
+		t.Fatalf("state.json says %s", status)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("wait returned while the client runs: %v", err)
+	case <-time.After(10 * testInterval):
+	}
+
+	if err := c.Signal(unix.SIGKILL, mcs.ClientTask{Name: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait missed the kill")
+	}
+	status, exit := onDisk(t, c)
+	if status != specs.StateStopped || exit == nil || exit.Status != monitor.KilledStatus(unix.SIGKILL) {
+		t.Errorf("state.json says %s, exit %+v; want stopped by SIGKILL", status, exit)
+	}
+
+	if err := c.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countCalls(srv.Calls(), "rm zephyr01"); n != 1 {
+		t.Errorf("micad was asked to remove the client %d times", n)
+	}
+	if _, err := os.Stat(c.StateDir()); !os.IsNotExist(err) {
+		t.Errorf("state dir still there: %v", err)
+	}
+}
+
+// A client checkpointed with its state exported comes back from it.
+func TestCheckpointRestoreMicad(t *testing.T) {
+	srv := newMicadServer(t, false)
+	c := newMicadContainer(t, srv, false)
+	c.setMicadProtocol(communication.ProtocolFramed)
+	imagePath := filepath.Join(t.TempDir(), "image")
+	if err := c.Checkpoint(&libcontainer.CriuOpts{ImagesDirectory: imagePath}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Client("zephyr01"); ok {
+		t.Fatal("checkpoint left the client behind")
+	}
+	if status, exit := onDisk(t, c); status != specs.StateStopped || exit == nil {
+		t.Errorf("state.json says %s, exit %+v", status, exit)
+	}
+
+	restored, err := Create(t.TempDir(), "zephyr02", c.bundle, c.config, srv.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.setMicadProtocol(communication.ProtocolFramed)
+	if err := restored.Restore(&libcontainer.CriuOpts{ImagesDirectory: imagePath}); err != nil {
+		t.Fatal(err)
+	}
+	client, ok := srv.Client("zephyr01")
+	if !ok || client.State != communication.ClientRunning || string(client.Image) != "zephyr01@0" {
+		t.Errorf("client %+v, want it running from its exported state", client)
+	}
+	if n := countCalls(srv.Calls(), "restore zephyr01"); n != 1 {
+		t.Errorf("micad restored the client %d times", n)
+	}
+}
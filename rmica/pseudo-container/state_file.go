@@ -0,0 +1,137 @@
+package pseudo_container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"rmica/defs"
+	"rmica/logger"
+	"rmica/monitor"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// stateVersionLegacy marks a state.json holding a bare specs.State,
+	// as written before rmica kept its own record.
+	stateVersionLegacy = 0
+	// stateVersion is the layout written by saveState.
+	stateVersion = 1
+)
+
+// stateRecord is what saveState writes to state.json.
+// specs.State is embedded so that the file still reads as an OCI state.
+type stateRecord struct {
+	specs.State
+	RecordVersion int         `json:"rmicaStateVersion"`
+	Config        *specs.Spec `json:"config,omitempty"`
+	Created       time.Time   `json:"created"`
+	ClientName    string      `json:"clientName,omitempty"`
+	MicaDir       string      `json:"micaDir,omitempty"`
+	// Exit is set once the client stopped
+	Exit *monitor.Exit `json:"exit,omitempty"`
+	// Updating is the pid of the rmica update that is creating the client
+	// again on another cpu, the client going away meanwhile is no exit
+	Updating int `json:"updating,omitempty"`
+}
+
+func (c *Container) newStateRecord(s *specs.State) *stateRecord {
+	return &stateRecord{
+		State:         *s,
+		RecordVersion: stateVersion,
+		Config:        c.config,
+		Created:       c.created,
+		ClientName:    c.clientName,
+		MicaDir:       c.micaDir,
+		Exit:          c.exit,
+		Updating:      c.updating,
+	}
+}
+
+// readStateRecord reads state.json of the container in stateDir.
+// Legacy files come back with RecordVersion == stateVersionLegacy and
+// only the OCI state filled in.
+func readStateRecord(stateDir string) (*stateRecord, error) {
+	statePath := filepath.Join(stateDir, defs.StateFilename)
+	f, err := os.Open(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, utils.ErrNotExist
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	rec := &stateRecord{}
+	if err := json.NewDecoder(f).Decode(rec); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to decode %s: %w", statePath, err)
+		}
+		// older rmica truncated state.json without writing it back
+		logger.Debugf("empty %s, assuming a stopped legacy container", statePath)
+		rec.Status = specs.StateStopped
+	}
+
+	switch rec.RecordVersion {
+	case stateVersionLegacy:
+		if fi, err := f.Stat(); err == nil {
+			rec.Created = fi.ModTime()
+		}
+	case stateVersion:
+	default:
+		return nil, fmt.Errorf("%s has version %d, this rmica only reads up to %d",
+			statePath, rec.RecordVersion, stateVersion)
+	}
+	return rec, nil
+}
+
+// updating tells whether the rmica update recorded in rec still runs. The
+// marker of one that died is stale.
+func (rec *stateRecord) updating() bool {
+	if rec.Updating == 0 {
+		return false
+	}
+	err := unix.Kill(rec.Updating, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
+
+// lockState takes the flock on the state dir that rmica processes hold
+// while they decide on state.json with what another one wrote there. The
+// returned func releases it.
+func (c *Container) lockState() (func(), error) {
+	dir, err := os.Open(c.StateDir())
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(dir.Fd()), unix.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", c.StateDir(), err)
+	}
+	return func() {
+		unix.Flock(int(dir.Fd()), unix.LOCK_UN)
+		dir.Close()
+	}, nil
+}
+
+// stateFromStatus rebuilds the state machine from a persisted status.
+func (c *Container) stateFromStatus(status specs.ContainerState) ContainerState {
+	switch status {
+	case specs.StateCreated:
+		return &CreatedState{c: c}
+	case specs.StateRunning:
+		return &RunningState{c: c}
+	case StatePaused:
+		return &PausedState{c: c}
+	default:
+		// creating is not a resting state, a container left in it never
+		// finished create and is as good as stopped
+		return &StoppedState{c: c}
+	}
+}
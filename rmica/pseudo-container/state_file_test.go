@@ -0,0 +1,157 @@
+package pseudo_container
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/defs"
+	"rmica/monitor"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// installState copies the fixture into a fresh state dir of container
+// zephyr01 below a new root and returns the state dir.
+func installState(t *testing.T, fixture string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateDir := filepath.Join(t.TempDir(), "zephyr01")
+	if err := os.Mkdir(stateDir, 0o711); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, defs.StateFilename), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return stateDir
+}
+
+func TestReadStateRecord(t *testing.T) {
+	mtime := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		fixture string
+		// err is a substring of the expected error, "" for none
+		err     string
+		version int
+		status  specs.ContainerState
+		pid     int
+		created time.Time
+		client  string
+		micaDir string
+		exit    *monitor.Exit
+	}{
+		{
+			fixture: "state-v0.json",
+			version: stateVersionLegacy,
+			status:  specs.StateRunning,
+			pid:     4242,
+			// a legacy state.json has no creation time, its mtime stands in
+			created: mtime,
+		},
+		{
+			fixture: "state-v1.json",
+			version: stateVersion,
+			status:  specs.StateStopped,
+			created: time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC),
+			client:  "zephyr01",
+			micaDir: "/run/mica",
+			exit:    &monitor.Exit{Status: 143, ExitedAt: time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)},
+		},
+		{
+			fixture: "state-empty.json",
+			version: stateVersionLegacy,
+			status:  specs.StateStopped,
+			created: mtime,
+		},
+		{fixture: "state-v9.json", err: "has version 9"},
+		{fixture: "state-garbage.json", err: "failed to decode"},
+	} {
+		t.Run(tc.fixture, func(t *testing.T) {
+			stateDir := installState(t, tc.fixture)
+			if err := os.Chtimes(filepath.Join(stateDir, defs.StateFilename), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			rec, err := readStateRecord(stateDir)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.RecordVersion != tc.version || rec.Status != tc.status || rec.Pid != tc.pid {
+				t.Errorf("version %d, status %s, pid %d; want %d, %s, %d",
+					rec.RecordVersion, rec.Status, rec.Pid, tc.version, tc.status, tc.pid)
+			}
+			if !rec.Created.Equal(tc.created) {
+				t.Errorf("created %v, want %v", rec.Created, tc.created)
+			}
+			if rec.ClientName != tc.client || rec.MicaDir != tc.micaDir {
+				t.Errorf("client %q in %q, want %q in %q", rec.ClientName, rec.MicaDir, tc.client, tc.micaDir)
+			}
+			if !reflect.DeepEqual(rec.Exit, tc.exit) {
+				t.Errorf("exit %+v, want %+v", rec.Exit, tc.exit)
+			}
+		})
+	}
+}
+
+func TestReadStateRecordMissing(t *testing.T) {
+	if _, err := readStateRecord(t.TempDir()); !errors.Is(err, utils.ErrNotExist) {
+		t.Errorf("got %v, want ErrNotExist", err)
+	}
+}
+
+// A v1 record written by saveState reads back into the same container.
+func TestStateRecordRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "zephyr01"), 0o711); err != nil {
+		t.Fatal(err)
+	}
+	exit := &monitor.Exit{Status: monitor.KilledStatus(15), ExitedAt: time.Date(2026, 10, 2, 7, 0, 0, 0, time.UTC)}
+	c := &Container{
+		id:     "zephyr01",
+		root:   root,
+		bundle: "/var/lib/rmica/bundles/zephyr01",
+		config: &specs.Spec{
+			Version:     specs.Version,
+			Annotations: map[string]string{defs.MicaAnnoClientCPU: "3"},
+		},
+		created:    time.Date(2026, 10, 2, 6, 0, 0, 0, time.UTC),
+		clientName: "zephyr01",
+		micaDir:    "/run/mica",
+		exit:       exit,
+	}
+	c.cstate = &StoppedState{c: c}
+	if _, err := c.updateState(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(root, "zephyr01", communication.SocketSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Status() != specs.StateStopped || loaded.Bundle() != c.bundle || loaded.ClientName() != "zephyr01" {
+		t.Errorf("loaded %s %s %s", loaded.Status(), loaded.Bundle(), loaded.ClientName())
+	}
+	if !loaded.Created().Equal(c.created) || loaded.micaDir != "/run/mica" {
+		t.Errorf("created %v in %s", loaded.Created(), loaded.micaDir)
+	}
+	if !reflect.DeepEqual(loaded.Exit(), exit) {
+		t.Errorf("exit %+v, want %+v", loaded.Exit(), exit)
+	}
+	if !reflect.DeepEqual(loaded.config, c.config) {
+		t.Errorf("config %+v, want %+v", loaded.config, c.config)
+	}
+}
@@ -4,7 +4,7 @@ import "rmica/mcs"
 
 type Stats struct {
 	// NetworkInterface []*types.NetworkInterface
-	ClientStates *mcs.ClientStats
+	ClientStates *mcs.ClientStats `json:"client,omitempty"`
 }
 
 func NewEmpty() Stats {
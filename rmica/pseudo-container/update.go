@@ -0,0 +1,263 @@
+package pseudo_container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"rmica/communication"
+	"rmica/defs"
+	"rmica/logger"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ParseClientCPU reads a cpuset that names exactly one cpu, the only kind a
+// mica client can run on.
+func ParseClientCPU(cpuset string) (uint32, error) {
+	cpuset = strings.TrimSpace(cpuset)
+	cpu, err := strconv.ParseUint(cpuset, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("cpuset %q must name a single cpu, a mica client runs on one", cpuset)
+	}
+	return uint32(cpu), nil
+}
+
+// Update applies resources to the client. Only cpu.cpus means something to
+// a mica client: micad migrates the client to the new cpu, or, if it cannot,
+// the client is created again there and booted if it was running. The new
+// cpu must be online and not used by another client of micad. The
+// updated config is persisted.
+func (c *Container) Update(resources *specs.LinuxResources) error {
+	if resources == nil || resources.CPU == nil || resources.CPU.Cpus == "" {
+		return errors.New("nothing to update, a mica client only has a cpu")
+	}
+	cpu, err := ParseClientCPU(resources.CPU.Cpus)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	status := c.cstate.status()
+	if status == specs.StateStopped {
+		return fmt.Errorf("cannot update container %s: %w", c.id, utils.ErrNotRunning)
+	}
+	if c.config == nil {
+		return fmt.Errorf("container %s has no recorded config to update", c.id)
+	}
+	if cur, ok := c.config.Annotations[defs.MicaAnnoClientCPU]; ok && cur == strconv.FormatUint(uint64(cpu), 10) {
+		logger.Debugf("[container] client %s of id=%s already runs on cpu %d", c.client(), c.id, cpu)
+		return nil
+	}
+	ctx := context.Background()
+	if err := c.checkCPU(ctx, cpu); err != nil {
+		return err
+	}
+
+	config := withClientCPU(c.config, cpu)
+	_, err = c.micad.Migrate(ctx, c.client(), cpu)
+	switch {
+	case err == nil:
+		logger.Infof("[container] migrated client %s of id=%s to cpu %d", c.client(), c.id, cpu)
+	case errors.Is(err, communication.ErrNotSupported):
+		if err := c.beginUpdate(); err != nil {
+			return err
+		}
+		if err := c.recreateClient(ctx, config, status); err != nil {
+			return errors.Join(err, c.endUpdate())
+		}
+		c.config = config
+		return c.endUpdate()
+	default:
+		return fmt.Errorf("failed to migrate client %s to cpu %d: %w", c.client(), cpu, err)
+	}
+
+	c.config = config
+	_, err = c.updateState(nil)
+	return err
+}
+
+// beginUpdate marks state.json as being updated by this rmica before the
+// client is removed to be created again, so that the monitor does not take
+// the client going away for an exit. It fails if the client stopped on disk
+// already. Callers hold c.m and call endUpdate once the client is back.
+func (c *Container) beginUpdate() error {
+	unlock, err := c.lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		return err
+	}
+	if rec.Status == specs.StateStopped {
+		c.cstate = &StoppedState{c: c}
+		c.exit = rec.Exit
+		return fmt.Errorf("cannot update container %s: %w", c.id, utils.ErrNotRunning)
+	}
+	c.updating = os.Getpid()
+	_, err = c.updateState(nil)
+	return err
+}
+
+// endUpdate drops the mark of beginUpdate and persists the container,
+// unless `rmica kill` stopped it meanwhile. The kill then applies to the
+// client created again too.
+func (c *Container) endUpdate() error {
+	unlock, err := c.lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	c.updating = 0
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		return err
+	}
+	if rec.Status == specs.StateStopped {
+		c.cstate = &StoppedState{c: c}
+		c.exit = rec.Exit
+		ctx := context.Background()
+		if _, err := c.micad.Stop(ctx, c.client()); err != nil {
+			logger.Debugf("stop client %s after kill: %v", c.client(), err)
+		}
+		if c.killed() {
+			if _, err := c.micad.Remove(ctx, c.client()); err != nil {
+				logger.Debugf("remove client %s after kill: %v", c.client(), err)
+			}
+		}
+		return fmt.Errorf("container %s stopped while its client moved: %w", c.id, utils.ErrNotRunning)
+	}
+	_, err = c.updateState(nil)
+	return err
+}
+
+// withClientCPU returns a copy of config whose client runs on cpu, both in
+// the client.cpu annotation and in linux.resources.cpu.cpus. config itself
+// is left alone.
+func withClientCPU(config *specs.Spec, cpu uint32) *specs.Spec {
+	cpus := strconv.FormatUint(uint64(cpu), 10)
+	updated := *config
+	updated.Annotations = make(map[string]string, len(config.Annotations)+1)
+	for k, v := range config.Annotations {
+		updated.Annotations[k] = v
+	}
+	updated.Annotations[defs.MicaAnnoClientCPU] = cpus
+
+	if updated.Linux == nil {
+		updated.Linux = &specs.Linux{}
+	} else {
+		linux := *updated.Linux
+		updated.Linux = &linux
+	}
+	if updated.Linux.Resources == nil {
+		updated.Linux.Resources = &specs.LinuxResources{}
+	} else {
+		res := *updated.Linux.Resources
+		updated.Linux.Resources = &res
+	}
+	cpuRes := specs.LinuxCPU{}
+	if updated.Linux.Resources.CPU != nil {
+		cpuRes = *updated.Linux.Resources.CPU
+	}
+	cpuRes.Cpus = cpus
+	updated.Linux.Resources.CPU = &cpuRes
+	return &updated
+}
+
+// checkCPU makes sure cpu is online and micad has no other client there,
+// whether rmica created it or not.
+func (c *Container) checkCPU(ctx context.Context, cpu uint32) error {
+	online, err := utils.OnlineCPUs()
+	if err != nil {
+		return fmt.Errorf("cannot read online cpus: %w", err)
+	}
+	if !online[cpu] {
+		return fmt.Errorf("cpu %d is not online", cpu)
+	}
+
+	clients, err := c.micad.Clients(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list the clients of micad: %w", err)
+	}
+	for _, st := range clients {
+		if st.Name != c.client() && st.CPU == cpu {
+			return fmt.Errorf("cpu %d is used by client %s", cpu, st.Name)
+		}
+	}
+	return nil
+}
+
+// recreateClient moves the client to the cpu in config the hard way: it is
+// removed and created again, and booted if it was running. The new client
+// is checked before the old one is touched, and the old one is brought back
+// if the new one cannot be created or booted.
+func (c *Container) recreateClient(ctx context.Context, config *specs.Spec, status specs.ContainerState) error {
+	name := c.client()
+	if status == StatePaused {
+		return fmt.Errorf("micad cannot migrate client %s, resume container %s to restart it on the new cpu", name, c.id)
+	}
+	if err := utils.ValidateTaskSpecIn(c.bundle, config); err != nil {
+		return err
+	}
+	msg, err := communication.ParseAnnotations(config.Annotations, c.id, utils.RootfsIn(c.bundle, config))
+	if err != nil {
+		return err
+	}
+	if msg.ClientName() != name {
+		return fmt.Errorf("config names client %s, but the container runs %s", msg.ClientName(), name)
+	}
+	old, err := communication.ParseAnnotations(c.config.Annotations, c.id, utils.RootfsIn(c.bundle, c.config))
+	if err != nil {
+		return fmt.Errorf("cannot recreate client %s where it runs now: %w", name, err)
+	}
+
+	logger.Infof("[container] micad cannot migrate client %s of id=%s, restarting it on cpu %d", name, c.id, msg.CPU)
+	if status == specs.StateRunning {
+		if _, err := c.micad.Stop(ctx, name); err != nil {
+			return fmt.Errorf("failed to stop client %s: %w", name, err)
+		}
+	}
+	if _, err := c.micad.Remove(ctx, name); err != nil {
+		return c.rollbackClient(ctx, old, status, false, fmt.Errorf("failed to remove client %s: %w", name, err))
+	}
+	if _, err := c.micad.Create(ctx, msg); err != nil {
+		return c.rollbackClient(ctx, old, status, true, fmt.Errorf("failed to create client %s on cpu %d: %w", name, msg.CPU, err))
+	}
+	if status == specs.StateRunning {
+		if _, err := c.micad.Start(ctx, name); err != nil {
+			cause := fmt.Errorf("failed to start client %s on cpu %d: %w", name, msg.CPU, err)
+			c.micad.Stop(ctx, name)
+			if _, err := c.micad.Remove(ctx, name); err != nil {
+				return errors.Join(cause, fmt.Errorf("failed to remove client %s from cpu %d: %w", name, msg.CPU, err))
+			}
+			return c.rollbackClient(ctx, old, status, true, cause)
+		}
+	}
+	return nil
+}
+
+// rollbackClient brings the client back on its old cpu, as old describes
+// it, after moving it failed with cause. removed tells whether micad
+// removed it already.
+func (c *Container) rollbackClient(ctx context.Context, old *communication.CreateMsg, status specs.ContainerState, removed bool, cause error) error {
+	name := old.ClientName()
+	logger.Warnf("[container] %v, bringing client %s back on cpu %d", cause, name, old.CPU)
+	if removed {
+		if _, err := c.micad.Create(ctx, old); err != nil {
+			return errors.Join(cause, fmt.Errorf("client %s is lost, creating it again on cpu %d failed: %w", name, old.CPU, err))
+		}
+	}
+	if status == specs.StateRunning {
+		if _, err := c.micad.Start(ctx, name); err != nil {
+			return errors.Join(cause, fmt.Errorf("failed to restart client %s on cpu %d: %w", name, old.CPU, err))
+		}
+	}
+	return cause
+}
@@ -0,0 +1,232 @@
+package pseudo_container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rmica/communication"
+	"rmica/communication/micadtest"
+	"rmica/defs"
+	"rmica/mcs"
+	"rmica/utils"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// newMicadServer starts a micadtest server on a host with cpus 0-3 online.
+func newMicadServer(t *testing.T, legacy bool) *micadtest.Server {
+	t.Helper()
+	online := filepath.Join(t.TempDir(), "online")
+	if err := os.WriteFile(online, []byte("0-3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := utils.CPUOnlinePath
+	utils.CPUOnlinePath = online
+	t.Cleanup(func() { utils.CPUOnlinePath = old })
+
+	srv, err := micadtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Legacy = legacy
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// newMicadContainer creates container zephyr01 with its client on cpu 0
+// of srv, and boots it unless created.
+func newMicadContainer(t *testing.T, srv *micadtest.Server, created bool) *Container {
+	t.Helper()
+	firmware := filepath.Join(t.TempDir(), "zephyr.elf")
+	if err := os.WriteFile(firmware, []byte("\x7fELF zephyr"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec := &specs.Spec{
+		Version: specs.Version,
+		Annotations: map[string]string{
+			defs.MicaAnnoClientCPU:      "0",
+			defs.MicaAnnoClientFirmware: firmware,
+		},
+	}
+	c, err := Create(t.TempDir(), "zephyr01", t.TempDir(), spec, srv.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := communication.ParseAnnotations(spec.Annotations, c.id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Register(msg); err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		if err := c.setState(&CreatedState{c: c}); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if err := c.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// waitInBackground runs Wait on the container the way the monitor
+// process does, on a container of its own.
+func waitInBackground(t *testing.T, c *Container) <-chan error {
+	t.Helper()
+	m, err := Load(c.root, c.id, communication.SocketSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Wait(ctx, testInterval)
+		done <- err
+	}()
+	return done
+}
+
+func cpuUpdate(cpus string) *specs.LinuxResources {
+	return &specs.LinuxResources{CPU: &specs.LinuxCPU{Cpus: cpus}}
+}
+
+// Recreating the client on another cpu is no exit to the monitor, and the
+// monitor records the real exit afterwards.
+func TestUpdateRecreateWithMonitor(t *testing.T) {
+	srv := newMicadServer(t, true)
+	c := newMicadContainer(t, srv, false)
+	done := waitInBackground(t, c)
+	time.Sleep(5 * testInterval)
+
+	// the monitor sees the client gone before it is back
+	srv.Script(micadtest.OpCreate, "zephyr01", micadtest.Behavior{Delay: 20 * testInterval})
+	if err := c.Update(cpuUpdate("1")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("monitor took the update for an exit: %v", err)
+	case <-time.After(10 * testInterval):
+	}
+
+	client, ok := srv.Client("zephyr01")
+	if !ok || client.CPU != 1 || client.State != communication.ClientRunning {
+		t.Fatalf("client %+v, want it running on cpu 1", client)
+	}
+	rec, err := readStateRecord(c.StateDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != specs.StateRunning || rec.Exit != nil || rec.Updating != 0 {
+		t.Errorf("state.json says %s, exit %+v, updating %d", rec.Status, rec.Exit, rec.Updating)
+	}
+	if cpu := rec.Config.Annotations[defs.MicaAnnoClientCPU]; cpu != "1" {
+		t.Errorf("state.json records cpu %s", cpu)
+	}
+
+	if err := srv.Crash("zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor missed the crash")
+	}
+	rec, err = readStateRecord(c.StateDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != specs.StateStopped || rec.Exit == nil || rec.Exit.Status != 1 {
+		t.Errorf("state.json says %s, exit %+v; want crashed", rec.Status, rec.Exit)
+	}
+	if cpu := rec.Config.Annotations[defs.MicaAnnoClientCPU]; cpu != "1" {
+		t.Errorf("the monitor recorded cpu %s", cpu)
+	}
+}
+
+// An update of a container that stopped on disk leaves the client alone.
+func TestUpdateStoppedOnDisk(t *testing.T) {
+	srv := newMicadServer(t, true)
+	c := newMicadContainer(t, srv, false)
+	other, err := Load(c.root, c.id, communication.SocketSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Signal(unix.SIGTERM, mcs.ClientTask{Name: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+	before := len(srv.Calls())
+
+	err = c.Update(cpuUpdate("1"))
+	if !errors.Is(err, utils.ErrNotRunning) {
+		t.Fatalf("got %v, want ErrNotRunning", err)
+	}
+	for _, call := range srv.Calls()[before:] {
+		if call != "status zephyr01" {
+			t.Errorf("update asked micad to %s", call)
+		}
+	}
+	if c.Status() != specs.StateStopped {
+		t.Errorf("container is %s", c.Status())
+	}
+}
+
+// A created container keeps its client offline on the new cpu.
+func TestUpdateRecreateCreated(t *testing.T) {
+	srv := newMicadServer(t, true)
+	c := newMicadContainer(t, srv, true)
+	if err := c.Update(cpuUpdate("2")); err != nil {
+		t.Fatal(err)
+	}
+	client, ok := srv.Client("zephyr01")
+	if !ok || client.CPU != 2 || client.State != communication.ClientOffline {
+		t.Errorf("client %+v, want it offline on cpu 2", client)
+	}
+}
+
+// Without recreating, micad moves the client itself.
+func TestUpdateMigrate(t *testing.T) {
+	srv := newMicadServer(t, false)
+	c := newMicadContainer(t, srv, false)
+	c.setMicadProtocol(communication.ProtocolFramed)
+	if err := c.Update(cpuUpdate("3")); err != nil {
+		t.Fatal(err)
+	}
+	client, _ := srv.Client("zephyr01")
+	if client.CPU != 3 || client.State != communication.ClientRunning {
+		t.Errorf("client %+v, want it running on cpu 3", client)
+	}
+	for _, call := range srv.Calls() {
+		if call == "rm zephyr01" {
+			t.Error("migrate removed the client")
+		}
+	}
+}
+
+// The cpu of another client is taken.
+func TestUpdateBusyCPU(t *testing.T) {
+	srv := newMicadServer(t, true)
+	c := newMicadContainer(t, srv, false)
+	msg := &communication.CreateMsg{CPU: 2}
+	copy(msg.Name[:], "nuttx01")
+	if _, err := srv.SocketClient().Create(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(cpuUpdate("2")); err == nil {
+		t.Fatal("update moved the client onto the cpu of nuttx01")
+	}
+	if client, _ := srv.Client("zephyr01"); client.CPU != 0 {
+		t.Errorf("client moved to cpu %d", client.CPU)
+	}
+}
@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"rmica/defs"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// ErrInvalidAnnotation is wrapped by every problem ValidateTaskSpec reports.
+var ErrInvalidAnnotation = errors.New("invalid mica annotation")
+
+// sizes of the fixed fields of the micad CreateMsg, NUL included
+const (
+	createMsgNameLen = 32
+	createMsgPathLen = 128
+)
+
+// MicaAnnotations lists every org.openeuler.mica.* key rmica understands,
+// and whether a spec must set it.
+var MicaAnnotations = map[string]bool{
+	defs.MicaAnnoClientName:     false,
+	defs.MicaAnnoClientCPU:      true,
+	defs.MicaAnnoClientFirmware: true,
+	defs.MicaAnnoPedestal:       false,
+	defs.MicaAnnoPedestalConf:   false,
+	defs.MicaAnnoDebug:          false,
+	defs.MicaAnnoClientConsole:  false,
+	defs.MicaAnnoMicadSocket:    false,
+}
+
+// MicaPedestals lists the pedestals micad can boot a client on. A client
+// without a pedestal annotation runs on bare metal.
+var MicaPedestals = []string{"jailhouse", "xen"}
+
+// annotationProblems collects everything wrong with the annotations so that
+// users fix their config.json in one go.
+type annotationProblems []error
+
+func (p *annotationProblems) add(key, format string, args ...interface{}) {
+	*p = append(*p, fmt.Errorf("%w %s: %s", ErrInvalidAnnotation, key, fmt.Sprintf(format, args...)))
+}
+
+func validateMicaAnnotations(annotations map[string]string, rootfs string) error {
+	var problems annotationProblems
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !startWithMicaPrefix(k) {
+			continue
+		}
+		if _, ok := MicaAnnotations[k]; !ok {
+			if s := suggestAnnotation(k); s != "" {
+				problems.add(k, "unknown key, did you mean %s?", s)
+			} else {
+				problems.add(k, "unknown key")
+			}
+		}
+	}
+
+	required := make([]string, 0, len(MicaAnnotations))
+	for k, must := range MicaAnnotations {
+		if _, ok := annotations[k]; must && !ok {
+			required = append(required, k)
+		}
+	}
+	sort.Strings(required)
+	for _, k := range required {
+		problems.add(k, "is required")
+	}
+
+	if name, ok := annotations[defs.MicaAnnoClientName]; ok {
+		checkCStringLen(&problems, defs.MicaAnnoClientName, name, createMsgNameLen)
+		if strings.ContainsAny(name, "/ \t\n") {
+			problems.add(defs.MicaAnnoClientName, "%q must not contain '/' or spaces, micad names <name>.socket after it", name)
+		}
+	}
+
+	if cpu, ok := annotations[defs.MicaAnnoClientCPU]; ok {
+		checkCPU(&problems, cpu)
+	}
+
+	if firmware, ok := annotations[defs.MicaAnnoClientFirmware]; ok {
+		checkFirmware(&problems, firmware, rootfs)
+	}
+
+	if ped, ok := annotations[defs.MicaAnnoPedestal]; ok {
+		checkCStringLen(&problems, defs.MicaAnnoPedestal, ped, createMsgNameLen)
+	}
+	if pedCfg, ok := annotations[defs.MicaAnnoPedestalConf]; ok {
+		checkCStringLen(&problems, defs.MicaAnnoPedestalConf, pedCfg, createMsgPathLen)
+		if _, hasPed := annotations[defs.MicaAnnoPedestal]; !hasPed {
+			problems.add(defs.MicaAnnoPedestalConf, "is set but %s is not", defs.MicaAnnoPedestal)
+		}
+	}
+
+	if debug, ok := annotations[defs.MicaAnnoDebug]; ok {
+		if _, err := strconv.ParseBool(debug); err != nil {
+			problems.add(defs.MicaAnnoDebug, "%q is not a boolean", debug)
+		}
+	}
+
+	if console, ok := annotations[defs.MicaAnnoClientConsole]; ok && !filepath.IsAbs(console) {
+		problems.add(defs.MicaAnnoClientConsole, "%q must be an absolute path", console)
+	}
+
+	if socket, ok := annotations[defs.MicaAnnoMicadSocket]; ok {
+		if !filepath.IsAbs(socket) || filepath.Base(socket) != defs.MicaSocketName {
+			problems.add(defs.MicaAnnoMicadSocket, "%q must be an absolute path to %s", socket, defs.MicaSocketName)
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+func checkCStringLen(problems *annotationProblems, key, value string, size int) {
+	if len(value) >= size {
+		problems.add(key, "%q is %d bytes, micad accepts at most %d", value, len(value), size-1)
+	}
+}
+
+func checkCPU(problems *annotationProblems, value string) {
+	cpu, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		problems.add(defs.MicaAnnoClientCPU, "%q is not a cpu number", value)
+		return
+	}
+	online, err := OnlineCPUs()
+	if err != nil {
+		problems.add(defs.MicaAnnoClientCPU, "cannot read online cpus: %v", err)
+		return
+	}
+	if !online[uint32(cpu)] {
+		problems.add(defs.MicaAnnoClientCPU, "cpu %d is not online, online cpus: %s", cpu, formatCPUs(online))
+	}
+}
+
+// checkFirmware accepts a firmware shipped in the bundle rootfs or present
+// on the host, and makes sure the path handed to micad fits CreateMsg.Path.
+func checkFirmware(problems *annotationProblems, firmware, rootfs string) {
+	if firmware == "" {
+		problems.add(defs.MicaAnnoClientFirmware, "is empty")
+		return
+	}
+	resolved := ""
+	if rootfs != "" {
+		if p, err := securejoin.SecureJoin(rootfs, firmware); err == nil && isRegular(p) {
+			resolved = p
+		}
+	}
+	if resolved == "" && filepath.IsAbs(firmware) && isRegular(firmware) {
+		resolved = firmware
+	}
+	if resolved == "" {
+		where := "on the host"
+		if rootfs != "" {
+			where = fmt.Sprintf("in %s or on the host", rootfs)
+		}
+		problems.add(defs.MicaAnnoClientFirmware, "%s is not a file %s", firmware, where)
+		return
+	}
+	checkCStringLen(problems, defs.MicaAnnoClientFirmware, resolved, createMsgPathLen)
+}
+
+func isRegular(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// CPUOnlinePath is where OnlineCPUs reads the online cpus from.
+var CPUOnlinePath = "/sys/devices/system/cpu/online"
+
+// OnlineCPUs parses CPUOnlinePath ("0-3,6"). Hosts without sysfs fall back
+// to 0..NumCPU-1.
+func OnlineCPUs() (map[uint32]bool, error) {
+	data, err := os.ReadFile(CPUOnlinePath)
+	if os.IsNotExist(err) {
+		online := map[uint32]bool{}
+		for i := 0; i < runtime.NumCPU(); i++ {
+			online[uint32(i)] = true
+		}
+		return online, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUList(string(data))
+}
+
+// parseCPUList parses a kernel cpu list like "0-3,6".
+func parseCPUList(list string) (map[uint32]bool, error) {
+	online := map[uint32]bool{}
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.ParseUint(lo, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad cpu list %q: %w", list, err)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(hi, 10, 32); err != nil {
+				return nil, fmt.Errorf("bad cpu list %q: %w", list, err)
+			}
+		}
+		for c := first; c <= last; c++ {
+			online[uint32(c)] = true
+		}
+	}
+	return online, nil
+}
+
+func formatCPUs(cpus map[uint32]bool) string {
+	list := make([]int, 0, len(cpus))
+	for c := range cpus {
+		list = append(list, int(c))
+	}
+	sort.Ints(list)
+	strs := make([]string, len(list))
+	for i, c := range list {
+		strs[i] = strconv.Itoa(c)
+	}
+	return strings.Join(strs, ",")
+}
+
+// suggestAnnotation returns the known key closest to a mistyped one.
+func suggestAnnotation(key string) string {
+	item := annotationMicaItems(key)
+	best, bestDist := "", 4 // more than 3 edits away is not a typo
+	for known := range MicaAnnotations {
+		d := editDistance(strings.ToLower(item), annotationMicaItems(known))
+		if d < bestDist || (d == bestDist && known < best) {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// validateOCISpec checks the parts of the OCI spec rmica relies on.
+func validateOCISpec(spec *specs.Spec) error {
+	var errs []error
+	if spec.Version == "" {
+		errs = append(errs, errors.New("ociVersion is empty"))
+	}
+	if rootfs := BundleRootfs(spec); rootfs != "" {
+		if fi, err := os.Stat(rootfs); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("root.path %s is not a directory", spec.Root.Path))
+		}
+	}
+	if spec.Hooks != nil {
+		for _, hooks := range []struct {
+			name  string
+			hooks []specs.Hook
+		}{
+			{"prestart", spec.Hooks.Prestart},
+			{"createRuntime", spec.Hooks.CreateRuntime},
+			{"createContainer", spec.Hooks.CreateContainer},
+			{"startContainer", spec.Hooks.StartContainer},
+			{"poststart", spec.Hooks.Poststart},
+			{"poststop", spec.Hooks.Poststop},
+		} {
+			for i, h := range hooks.hooks {
+				if !filepath.IsAbs(h.Path) {
+					errs = append(errs, fmt.Errorf("hooks.%s[%d].path %q must be an absolute path", hooks.name, i, h.Path))
+				}
+				if h.Timeout != nil && *h.Timeout <= 0 {
+					errs = append(errs, fmt.Errorf("hooks.%s[%d].timeout must be greater than zero", hooks.name, i))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"rmica/defs"
+)
+
+// fakeOnlineCPUs makes OnlineCPUs report list for the rest of the test.
+func fakeOnlineCPUs(t *testing.T, list string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "online")
+	if err := os.WriteFile(path, []byte(list+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := CPUOnlinePath
+	CPUOnlinePath = path
+	t.Cleanup(func() { CPUOnlinePath = old })
+}
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("\x7fELF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnlineCPUs(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		want []uint32
+		err  bool
+	}{
+		{list: "0", want: []uint32{0}},
+		{list: "0-3", want: []uint32{0, 1, 2, 3}},
+		{list: "0-1,4,6-7", want: []uint32{0, 1, 4, 6, 7}},
+		{list: "", want: []uint32{}},
+		{list: "0-x", err: true},
+		{list: "a", err: true},
+	} {
+		t.Run(tc.list, func(t *testing.T) {
+			fakeOnlineCPUs(t, tc.list)
+			online, err := OnlineCPUs()
+			if tc.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", online)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[uint32]bool{}
+			for _, c := range tc.want {
+				want[c] = true
+			}
+			if !reflect.DeepEqual(online, want) {
+				t.Errorf("got %v, want %v", online, want)
+			}
+		})
+	}
+}
+
+func TestOnlineCPUsWithoutSysfs(t *testing.T) {
+	old := CPUOnlinePath
+	CPUOnlinePath = filepath.Join(t.TempDir(), "online")
+	defer func() { CPUOnlinePath = old }()
+	online, err := OnlineCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(online) != runtime.NumCPU() || !online[0] {
+		t.Errorf("got %v, want 0..%d", online, runtime.NumCPU()-1)
+	}
+}
+
+func TestSuggestAnnotation(t *testing.T) {
+	for key, want := range map[string]string{
+		defs.MicaAnnotationPrefix + "client.cpus":          defs.MicaAnnoClientCPU,
+		defs.MicaAnnotationPrefix + "client.CPU":           defs.MicaAnnoClientCPU,
+		defs.MicaAnnotationPrefix + "client.firmwre":       defs.MicaAnnoClientFirmware,
+		defs.MicaAnnotationPrefix + "client.pedestal-conf": defs.MicaAnnoPedestalConf,
+		defs.MicaAnnotationPrefix + "micad.sock":           defs.MicaAnnoMicadSocket,
+		defs.MicaAnnotationPrefix + "client.entrypoint":    "",
+	} {
+		if got := suggestAnnotation(key); got != want {
+			t.Errorf("suggestAnnotation(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestValidateMicaAnnotations(t *testing.T) {
+	fakeOnlineCPUs(t, "0-3")
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	writeFile(t, filepath.Join(rootfs, "lib/firmware/zephyr.elf"))
+	host := filepath.Join(t.TempDir(), "host-zephyr.elf")
+	writeFile(t, host)
+	// a firmware micad cannot take, its path does not fit CreateMsg.Path
+	deep := filepath.Join(rootfs, strings.Repeat("d", 100), "zephyr.elf")
+	writeFile(t, deep)
+
+	valid := func(extra map[string]string) map[string]string {
+		annotations := map[string]string{
+			defs.MicaAnnoClientCPU:      "3",
+			defs.MicaAnnoClientFirmware: "/lib/firmware/zephyr.elf",
+		}
+		for k, v := range extra {
+			if v == "-" {
+				delete(annotations, k)
+				continue
+			}
+			annotations[k] = v
+		}
+		return annotations
+	}
+
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		rootfs      string
+		// substrings of the reported problems, in order; none for valid
+		problems []string
+	}{
+		{name: "valid", annotations: valid(nil), rootfs: rootfs},
+		{
+			name:        "not mica annotations are ignored",
+			annotations: valid(map[string]string{"io.kubernetes.cri.sandbox-id": "abc"}),
+			rootfs:      rootfs,
+		},
+		{
+			name:        "typo",
+			annotations: valid(map[string]string{defs.MicaAnnotationPrefix + "client.cpus": "3"}),
+			rootfs:      rootfs,
+			problems:    []string{"client.cpus: unknown key, did you mean " + defs.MicaAnnoClientCPU + "?"},
+		},
+		{
+			name:        "unknown key",
+			annotations: valid(map[string]string{defs.MicaAnnotationPrefix + "client.entrypoint": "/bin/sh"}),
+			rootfs:      rootfs,
+			problems:    []string{"client.entrypoint: unknown key"},
+		},
+		{
+			name:        "required keys",
+			annotations: map[string]string{},
+			problems:    []string{defs.MicaAnnoClientCPU + ": is required", defs.MicaAnnoClientFirmware + ": is required"},
+		},
+		{
+			name:        "cpu not a number",
+			annotations: valid(map[string]string{defs.MicaAnnoClientCPU: "three"}),
+			rootfs:      rootfs,
+			problems:    []string{`"three" is not a cpu number`},
+		},
+		{
+			name:        "cpu offline",
+			annotations: valid(map[string]string{defs.MicaAnnoClientCPU: "7"}),
+			rootfs:      rootfs,
+			problems:    []string{"cpu 7 is not online, online cpus: 0,1,2,3"},
+		},
+		{
+			name:        "firmware on the host",
+			annotations: valid(map[string]string{defs.MicaAnnoClientFirmware: host}),
+			rootfs:      rootfs,
+		},
+		{
+			name:        "firmware on the host without rootfs",
+			annotations: valid(map[string]string{defs.MicaAnnoClientFirmware: host}),
+		},
+		{
+			name:        "firmware only in the rootfs needs the rootfs",
+			annotations: valid(nil),
+			problems:    []string{"/lib/firmware/zephyr.elf is not a file on the host"},
+		},
+		{
+			name:        "firmware nowhere",
+			annotations: valid(map[string]string{defs.MicaAnnoClientFirmware: "/lib/firmware/nuttx.elf"}),
+			rootfs:      rootfs,
+			problems:    []string{"/lib/firmware/nuttx.elf is not a file in " + rootfs + " or on the host"},
+		},
+		{
+			name:        "firmware is a directory",
+			annotations: valid(map[string]string{defs.MicaAnnoClientFirmware: "/lib/firmware"}),
+			rootfs:      rootfs,
+			problems:    []string{"is not a file"},
+		},
+		{
+			name:        "firmware path too long",
+			annotations: valid(map[string]string{defs.MicaAnnoClientFirmware: strings.TrimPrefix(deep, rootfs)}),
+			rootfs:      rootfs,
+			problems:    []string{"micad accepts at most 127"},
+		},
+		{
+			name:        "name at the limit",
+			annotations: valid(map[string]string{defs.MicaAnnoClientName: strings.Repeat("n", 31)}),
+			rootfs:      rootfs,
+		},
+		{
+			name:        "name too long",
+			annotations: valid(map[string]string{defs.MicaAnnoClientName: strings.Repeat("n", 32)}),
+			rootfs:      rootfs,
+			problems:    []string{"is 32 bytes, micad accepts at most 31"},
+		},
+		{
+			name:        "name with a slash",
+			annotations: valid(map[string]string{defs.MicaAnnoClientName: "a/b"}),
+			rootfs:      rootfs,
+			problems:    []string{`"a/b" must not contain '/' or spaces`},
+		},
+		{
+			name:        "pedestal config without pedestal",
+			annotations: valid(map[string]string{defs.MicaAnnoPedestalConf: "/etc/mica/jailhouse.cell"}),
+			rootfs:      rootfs,
+			problems:    []string{defs.MicaAnnoPedestalConf + ": is set but " + defs.MicaAnnoPedestal + " is not"},
+		},
+		{
+			name: "pedestal config with pedestal",
+			annotations: valid(map[string]string{
+				defs.MicaAnnoPedestal:     "jailhouse",
+				defs.MicaAnnoPedestalConf: "/etc/mica/jailhouse.cell",
+			}),
+			rootfs: rootfs,
+		},
+		{
+			name:        "debug not a boolean",
+			annotations: valid(map[string]string{defs.MicaAnnoDebug: "yes"}),
+			rootfs:      rootfs,
+			problems:    []string{`"yes" is not a boolean`},
+		},
+		{
+			name:        "relative console",
+			annotations: valid(map[string]string{defs.MicaAnnoClientConsole: "dev/ttyRPMSG0"}),
+			rootfs:      rootfs,
+			problems:    []string{"must be an absolute path"},
+		},
+		{
+			name:        "socket not mica-create.socket",
+			annotations: valid(map[string]string{defs.MicaAnnoMicadSocket: "/run/mica/micad.sock"}),
+			rootfs:      rootfs,
+			problems:    []string{"must be an absolute path to " + defs.MicaSocketName},
+		},
+		{
+			name: "every problem at once",
+			annotations: map[string]string{
+				defs.MicaAnnotationPrefix + "client.firmwre": "/lib/firmware/zephyr.elf",
+				defs.MicaAnnoClientCPU:                       "9",
+				defs.MicaAnnoPedestalConf:                    "/etc/mica/xen.cfg",
+			},
+			rootfs: rootfs,
+			problems: []string{
+				"did you mean " + defs.MicaAnnoClientFirmware,
+				defs.MicaAnnoClientFirmware + ": is required",
+				"cpu 9 is not online",
+				"is set but " + defs.MicaAnnoPedestal + " is not",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateMicaAnnotations(tc.annotations, tc.rootfs)
+			if len(tc.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidAnnotation) {
+				t.Fatalf("got %v, want ErrInvalidAnnotation", err)
+			}
+			joined, ok := err.(interface{ Unwrap() []error })
+			if !ok {
+				t.Fatalf("%T does not join the problems", err)
+			}
+			problems := joined.Unwrap()
+			if len(problems) != len(tc.problems) {
+				t.Fatalf("got %d problems, want %d:\n%v", len(problems), len(tc.problems), err)
+			}
+			for i, want := range tc.problems {
+				if !errors.Is(problems[i], ErrInvalidAnnotation) {
+					t.Errorf("problem %d does not wrap ErrInvalidAnnotation: %v", i, problems[i])
+				}
+				if !strings.Contains(problems[i].Error(), want) {
+					t.Errorf("problem %d is %q, want it to contain %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+// LoadSpec reports the problems with the OCI part and the annotations of a
+// config.json together.
+func TestLoadSpec(t *testing.T) {
+	fakeOnlineCPUs(t, "0-3")
+	bundle := t.TempDir()
+	config := filepath.Join(bundle, defs.SpecConfig)
+	err := os.WriteFile(config, []byte(`{
+		"ociVersion": "",
+		"root": {"path": "rootfs"},
+		"annotations": {"`+defs.MicaAnnoClientCPU+`": "1"}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(config)
+	if spec == nil || err == nil {
+		t.Fatalf("got %v, %v; want the spec and an error", spec, err)
+	}
+	for _, want := range []string{"ociVersion is empty", defs.MicaAnnoClientFirmware + ": is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q does not contain %q", err, want)
+		}
+	}
+}
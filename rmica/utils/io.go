@@ -1,8 +1,68 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
 	"github.com/containerd/console"
+	"golang.org/x/sys/unix"
 )
-type tty struct {
-	console *console.Console
-}
\ No newline at end of file
+
+// Tty is the pseudoterminal the console of a client is proxied through.
+// Its master end belongs to whoever listens on the console socket, the
+// slave end to the proxy copying to and from the client console.
+type Tty struct {
+	console console.Console
+	// Slave is the end the proxy works on
+	Slave *os.File
+}
+
+// Close releases both ends held by this process, the console socket and the
+// proxy keep their own.
+func (t *Tty) Close() error {
+	return errors.Join(t.console.Close(), t.Slave.Close())
+}
+
+// SetupIO allocates a pty and hands its master to consoleSocket, the way
+// runc does for a container with a terminal.
+func SetupIO(consoleSocket string) (*Tty, error) {
+	conn, err := net.Dial("unix", consoleSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to console socket %s: %w", consoleSocket, err)
+	}
+	defer conn.Close()
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("casting to UnixConn failed")
+	}
+	socket, err := uc.File()
+	if err != nil {
+		return nil, err
+	}
+	defer socket.Close()
+
+	pty, slavePath, err := console.NewPty()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate a pty: %w", err)
+	}
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		pty.Close()
+		return nil, fmt.Errorf("failed to open pty slave %s: %w", slavePath, err)
+	}
+	t := &Tty{console: pty, Slave: slave}
+	if err := SendFd(socket, pty.Name(), pty.Fd()); err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to send pty master to %s: %w", consoleSocket, err)
+	}
+	return t, nil
+}
+
+// SendFd sends fd over socket with SCM_RIGHTS, msg goes along as payload,
+// the receiver of runc's console socket takes it for the name of the file.
+func SendFd(socket *os.File, msg string, fd uintptr) error {
+	oob := unix.UnixRights(int(fd))
+	return unix.Sendmsg(int(socket.Fd()), []byte(msg), oob, nil, 0)
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 
+	"rmica/communication"
 	"rmica/defs"
 	"rmica/logger"
 	"rmica/mcs"
@@ -56,6 +57,18 @@ func GetRootDir(context *cli.Context) string {
 	return root
 }
 
+// MicaSocketDir resolves where micad listens for a container, see
+// communication.ResolveSocketDir. recorded is the directory the container
+// was created against, "" for a new one.
+func MicaSocketDir(context *cli.Context, annotations map[string]string, recorded string) (string, error) {
+	return communication.ResolveSocketDir(communication.SocketSource{
+		FlagSocket:  context.GlobalString("mica-socket"),
+		FlagDir:     context.GlobalString("mica-dir"),
+		Recorded:    recorded,
+		Annotations: annotations,
+	})
+}
+
 func GetMicaTaskConfig() *mcs.ClientTask {
 	ct4Test := &mcs.ClientTask{
 		Name: "test",
@@ -109,6 +122,29 @@ func SetupSpec(context *cli.Context) (*specs.Spec, error) {
 	return spec, nil
 }
 
+// BundleRootfs returns the absolute rootfs path of the bundle that
+// SetupSpec changed into, or "" if the spec has no root.
+func BundleRootfs(spec *specs.Spec) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return RootfsIn(cwd, spec)
+}
+
+// RootfsIn returns the absolute rootfs path of spec for the bundle at
+// bundle, or "" if the spec has no root. Commands that act on an existing
+// container use it, they do not run in its bundle.
+func RootfsIn(bundle string, spec *specs.Spec) string {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return ""
+	}
+	if filepath.IsAbs(spec.Root.Path) {
+		return spec.Root.Path
+	}
+	return filepath.Join(bundle, spec.Root.Path)
+}
+
 // NOTICE: bundle内应该包含了 适配 clientRTOS运行 的 二进制 
 // NOTICE: add client task information to `annotations` field in OCI spec (config.json)
 // NOTICE: 
@@ -138,32 +174,41 @@ func LoadSpec(cPath string) (spec *specs.Spec, err error) {
 		return nil, errors.New("config cannot be null")
 	}
 	// return spec, validateProcessSpec(spec.Process)
-	return spec, ValidateTaskSpec(spec)
+	// check all of it before anything parses a part, so that every problem
+	// is reported at once
+	return spec, errors.Join(ValidateSpec(spec), ValidateTaskSpec(spec))
 }
 
 
-// TODO: Client task
-// TODO: a set of mica annotations must contains (basenames) 
-// {client.os, client.firmware, client.name, client.CPU, //client.entry --> task.path
-// task.path, task.args, task.envs, 
-// entry.user, ...?}
+// ValidateTaskSpec checks the org.openeuler.mica.* annotations that describe
+// the client. Every problem found is reported, each wrapping ErrInvalidAnnotation.
 func ValidateTaskSpec(spec *specs.Spec) error {
-	annotations := spec.Annotations
-	if annotations != nil {
-		for k, v := range annotations {
-			if startWithMicaPrefix(k) {
-				// expected format: <Category>.<item>
-				item := annotationMicaItems(k)
-				logger.Fprintf("caught %s:%s", item, v)
-			}
+	if spec == nil {
+		return errors.New("config cannot be null")
+	}
+	for k, v := range spec.Annotations {
+		if startWithMicaPrefix(k) {
+			logger.Debugf("caught %s:%s", annotationMicaItems(k), v)
 		}
 	}
-	return nil
+	return validateMicaAnnotations(spec.Annotations, BundleRootfs(spec))
+}
+
+// ValidateTaskSpecIn is ValidateTaskSpec for the bundle at bundle, for
+// commands that change the config of an existing container.
+func ValidateTaskSpecIn(bundle string, spec *specs.Spec) error {
+	if spec == nil {
+		return errors.New("config cannot be null")
+	}
+	return validateMicaAnnotations(spec.Annotations, RootfsIn(bundle, spec))
 }
 
-// TODO: Client task
+// ValidateSpec checks the OCI part of the spec.
 func ValidateSpec(spec *specs.Spec) error {
-	return nil
+	if spec == nil {
+		return errors.New("config cannot be null")
+	}
+	return validateOCISpec(spec)
 }
 
 // From runc::libcontainer
@@ -205,11 +250,6 @@ func getDefaultImagePath() string {
 	return filepath.Join(cwd, "checkpoint")
 }
 
-// NOTICE: consider the tty handler of mica
-func SetupIO() (*tty, error) {
-	return nil, nil
-}
-
 
 // Revise the value of flag "pid-file" to the absolute path.
 func RevisePidFile(context *cli.Context) error {
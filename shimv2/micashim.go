@@ -18,20 +18,39 @@ package micashim
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
 	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
 	apitypes "github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/v2/core/mount"
+	"github.com/containerd/containerd/v2/core/runtime"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/containerd/v2/pkg/protobuf"
 	ptypes "github.com/containerd/containerd/v2/pkg/protobuf/types"
 	"github.com/containerd/containerd/v2/pkg/shim"
 	"github.com/containerd/containerd/v2/pkg/shutdown"
 	"github.com/containerd/containerd/v2/plugins"
 	"github.com/containerd/errdefs"
+	"github.com/containerd/errdefs/pkg/errgrpc"
+	"github.com/containerd/log"
 	"github.com/containerd/plugin"
 	"github.com/containerd/plugin/registry"
 	"github.com/containerd/ttrpc"
+	"golang.org/x/sys/unix"
+
+	"rmica/communication"
+	"rmica/monitor"
 )
 
 const (
@@ -72,18 +91,137 @@ func (m manager) Name() string {
 	return m.name
 }
 
-func (m manager) Start(ctx context.Context, id string, opts shim.StartOpts) (shim.BootstrapParams, error) {
-	fmt.Printf("hello Start\n")
-	return shim.BootstrapParams{}, errdefs.ErrNotImplemented
+func newCommand(ctx context.Context, id, containerdAddress string, debug bool) (*exec.Cmd, error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"-namespace", ns,
+		"-id", id,
+		"-address", containerdAddress,
+	}
+	if debug {
+		args = append(args, "-debug")
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Dir = cwd
+	cmd.Env = os.Environ()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	return cmd, nil
+}
+
+// Start spawns the long running shim serving id, or hands out the address
+// of the one that already serves it.
+func (m manager) Start(ctx context.Context, id string, opts shim.StartOpts) (_ shim.BootstrapParams, retErr error) {
+	params := shim.BootstrapParams{
+		Version:  3,
+		Protocol: "ttrpc",
+	}
+
+	cmd, err := newCommand(ctx, id, opts.Address, opts.Debug)
+	if err != nil {
+		return params, err
+	}
+
+	address, err := shim.SocketAddress(ctx, opts.Address, id, opts.Debug)
+	if err != nil {
+		return params, err
+	}
+	socket, err := shim.NewSocket(address)
+	if err != nil {
+		if !shim.SocketEaddrinuse(err) {
+			return params, fmt.Errorf("create new shim socket: %w", err)
+		}
+		if !opts.Debug && shim.CanConnect(address) {
+			params.Address = address
+			return params, nil
+		}
+		if err := shim.RemoveSocket(address); err != nil {
+			return params, fmt.Errorf("remove pre-existing socket: %w", err)
+		}
+		if socket, err = shim.NewSocket(address); err != nil {
+			return params, fmt.Errorf("try create new shim socket 2x: %w", err)
+		}
+	}
+	defer func() {
+		if retErr != nil {
+			socket.Close()
+			_ = shim.RemoveSocket(address)
+		}
+	}()
+
+	if !opts.Debug {
+		if err := shim.WriteAddress("address", address); err != nil {
+			return params, err
+		}
+	}
+
+	f, err := socket.File()
+	if err != nil {
+		return params, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+
+	goruntime.LockOSThread()
+	err = cmd.Start()
+	goruntime.UnlockOSThread()
+	f.Close()
+	if err != nil {
+		return params, err
+	}
+	defer func() {
+		if retErr != nil {
+			cmd.Process.Kill()
+		}
+	}()
+	// make sure to wait after start
+	go cmd.Wait()
+
+	params.Address = address
+	return params, nil
 }
 
+// Stop cleans up after a shim that is gone: the client is removed from
+// micad and the rootfs unmounted.
 func (m manager) Stop(ctx context.Context, id string) (shim.StopStatus, error) {
-	fmt.Printf("hello Stop\n")
-	return shim.StopStatus{}, errdefs.ErrNotImplemented
+	cwd, err := os.Getwd()
+	if err != nil {
+		return shim.StopStatus{}, err
+	}
+	bundle := filepath.Join(filepath.Dir(cwd), id)
+
+	client, err := readClientFile(bundle)
+	if err == nil {
+		if _, err := bundleMicad(bundle).Remove(ctx, client); err != nil &&
+			!errors.Is(err, communication.ErrConnRefused) {
+			log.G(ctx).WithError(err).Warnf("failed to remove mica client %s", client)
+		}
+	} else if !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).Warn("failed to read mica client name")
+	}
+
+	if err := mount.UnmountAll(filepath.Join(bundle, "rootfs"), 0); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to cleanup rootfs mount")
+	}
+
+	return shim.StopStatus{
+		ExitedAt:   time.Now(),
+		ExitStatus: monitor.KilledStatus(unix.SIGKILL),
+	}, nil
 }
 
 func (m manager) Info(ctx context.Context, optionsR io.Reader) (*apitypes.RuntimeInfo, error) {
-	fmt.Printf("hello Info\n")
 	info := &apitypes.RuntimeInfo{
 		Name: MicaShim,
 		Version: &apitypes.RuntimeVersion{
@@ -94,14 +232,40 @@ func (m manager) Info(ctx context.Context, optionsR io.Reader) (*apitypes.Runtim
 }
 
 func newTaskService(ctx context.Context, publisher shim.Publisher, sd shutdown.Service) (taskAPI.TaskService, error) {
-	return &micaTaskService{}, nil
+	s := &micaTaskService{
+		tasks:    make(map[string]*micaTask),
+		interval: monitor.DefaultInterval,
+		wake:     make(chan struct{}, 1),
+		shutdown: sd,
+	}
+	go s.forward(ctx, publisher)
+	sd.RegisterCallback(func(context.Context) error {
+		s.closeEvents()
+		return nil
+	})
+	return s, nil
 }
 
 var (
 	_ = shim.TTRPCService(&micaTaskService{})
 )
 
+// micaTaskService serves the containerd task API by driving micad.
+// Each task is one mica client, exec processes are not supported.
 type micaTaskService struct {
+	mu    sync.Mutex
+	tasks map[string]*micaTask
+	// interval at which watch asks micad about started tasks
+	interval time.Duration
+
+	// evmu guards the events queued for forward. send is called with s.mu
+	// held, so it only queues and never waits for the publisher.
+	evmu    sync.Mutex
+	pending []interface{}
+	closed  bool
+	wake    chan struct{}
+
+	shutdown shutdown.Service
 }
 
 // RegisterTTRPC allows TTRPC services to be registered with the underlying server
@@ -110,105 +274,478 @@ func (s *micaTaskService) RegisterTTRPC(server *ttrpc.Server) error {
 	return nil
 }
 
+// getTask must be called with s.mu held.
+func (s *micaTaskService) getTask(id, execID string) (*micaTask, error) {
+	if execID != "" {
+		return nil, fmt.Errorf("exec processes: %w", errdefs.ErrNotImplemented)
+	}
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, fmt.Errorf("task %s: %w", id, errdefs.ErrNotFound)
+	}
+	return t, nil
+}
+
 // Create a new container
 func (s *micaTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *taskAPI.CreateTaskResponse, err error) {
-	fmt.Printf("hello Create\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tasks[r.ID]; ok {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrAlreadyExists, "task %s", r.ID)
+	}
+	if r.Terminal {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrNotImplemented, "terminal for mica clients")
+	}
+	if r.Checkpoint != "" {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrNotImplemented, "restore from checkpoint")
+	}
+
+	spec, err := readSpec(r.Bundle)
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+
+	var mounts []mount.Mount
+	for _, m := range r.Rootfs {
+		mounts = append(mounts, mount.Mount{
+			Type:    m.Type,
+			Source:  m.Source,
+			Target:  m.Target,
+			Options: m.Options,
+		})
+	}
+	rootfs := ""
+	if len(mounts) > 0 {
+		rootfs = filepath.Join(r.Bundle, "rootfs")
+		if err := os.Mkdir(rootfs, 0o711); err != nil && !os.IsExist(err) {
+			return nil, errgrpc.ToGRPC(err)
+		}
+		if err := mount.All(mounts, rootfs); err != nil {
+			return nil, errgrpc.ToGRPC(fmt.Errorf("failed to mount rootfs component: %w", err))
+		}
+	}
+	defer func() {
+		if err != nil && rootfs != "" {
+			if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
+				log.G(ctx).WithError(err2).Warn("failed to cleanup rootfs mount")
+			}
+		}
+	}()
+
+	msg, err := communication.ParseAnnotations(spec.Annotations, r.ID, specRootfs(r.Bundle, spec))
+	if err != nil {
+		return nil, errgrpc.ToGRPC(fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument))
+	}
+	micaDir, err := communication.ResolveSocketDir(communication.SocketSource{Annotations: spec.Annotations})
+	if err != nil {
+		return nil, errgrpc.ToGRPC(fmt.Errorf("%v: %w", err, errdefs.ErrInvalidArgument))
+	}
+	micad := communication.NewSocketClient(micaDir)
+	if _, err := micad.Create(ctx, msg); err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	client := msg.ClientName()
+	if err := writeClientFile(r.Bundle, client); err != nil {
+		micad.Remove(context.WithoutCancel(ctx), client)
+		return nil, errgrpc.ToGRPC(err)
+	}
+
+	t := &micaTask{
+		id:       r.ID,
+		bundle:   r.Bundle,
+		rootfs:   rootfs,
+		client:   client,
+		micad:    micad,
+		pid:      uint32(os.Getpid()),
+		status:   task.Status_CREATED,
+		stdin:    r.Stdin,
+		stdout:   r.Stdout,
+		stderr:   r.Stderr,
+		terminal: r.Terminal,
+		exited:   make(chan struct{}),
+	}
+	s.tasks[r.ID] = t
+	log.G(ctx).Debugf("created mica client %s for task %s", client, r.ID)
+
+	s.send(&eventstypes.TaskCreate{
+		ContainerID: r.ID,
+		Bundle:      r.Bundle,
+		Rootfs:      r.Rootfs,
+		IO: &eventstypes.TaskIO{
+			Stdin:    r.Stdin,
+			Stdout:   r.Stdout,
+			Stderr:   r.Stderr,
+			Terminal: r.Terminal,
+		},
+		Checkpoint: r.Checkpoint,
+		Pid:        t.pid,
+	})
+
+	return &taskAPI.CreateTaskResponse{Pid: t.pid}, nil
 }
 
 // Start the primary user process inside the container
 func (s *micaTaskService) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.StartResponse, error) {
-	fmt.Printf("hello Start\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, r.ExecID)
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	if t.status != task.Status_CREATED {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is %s", r.ID, t.status)
+	}
+	if _, err := t.micad.Start(ctx, t.client); err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	t.status = task.Status_RUNNING
+	go s.watch(t)
+
+	s.send(&eventstypes.TaskStart{
+		ContainerID: t.id,
+		Pid:         t.pid,
+	})
+	return &taskAPI.StartResponse{Pid: t.pid}, nil
 }
 
 // Delete a process or container
 func (s *micaTaskService) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAPI.DeleteResponse, error) {
-	fmt.Printf("hello Delete\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, r.ExecID)
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	if t.status == task.Status_RUNNING || t.status == task.Status_PAUSED {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "cannot delete task %s, it is %s", r.ID, t.status)
+	}
+
+	if _, err := t.micad.Remove(ctx, t.client); err != nil && !errors.Is(err, communication.ErrConnRefused) {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	if t.rootfs != "" {
+		if err := mount.UnmountAll(t.rootfs, 0); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to cleanup rootfs mount")
+		}
+	}
+	os.Remove(filepath.Join(t.bundle, clientFile))
+	// a created task that never ran leaves without an exit
+	t.setExited(t.exitStatus)
+	delete(s.tasks, r.ID)
+
+	s.send(&eventstypes.TaskDelete{
+		ContainerID: t.id,
+		Pid:         t.pid,
+		ExitStatus:  t.exitStatus,
+		ExitedAt:    protobuf.ToTimestamp(t.exitedAt),
+	})
+	return &taskAPI.DeleteResponse{
+		Pid:        t.pid,
+		ExitStatus: t.exitStatus,
+		ExitedAt:   protobuf.ToTimestamp(t.exitedAt),
+	}, nil
 }
 
 // Exec an additional process inside the container
 func (s *micaTaskService) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Exec\n")
 	return nil, errdefs.ErrNotImplemented
 }
 
 // ResizePty of a process
 func (s *micaTaskService) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello ResizePty\n")
 	return nil, errdefs.ErrNotImplemented
 }
 
 // State returns runtime state of a process
 func (s *micaTaskService) State(ctx context.Context, r *taskAPI.StateRequest) (*taskAPI.StateResponse, error) {
-	fmt.Printf("hello State\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, r.ExecID)
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	return &taskAPI.StateResponse{
+		ID:         t.id,
+		Bundle:     t.bundle,
+		Pid:        t.pid,
+		Status:     t.status,
+		Stdin:      t.stdin,
+		Stdout:     t.stdout,
+		Stderr:     t.stderr,
+		Terminal:   t.terminal,
+		ExitStatus: t.exitStatus,
+		ExitedAt:   protobuf.ToTimestamp(t.exitedAt),
+	}, nil
 }
 
 // Pause the container
 func (s *micaTaskService) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Pause\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, "")
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	if t.status != task.Status_RUNNING {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is %s", r.ID, t.status)
+	}
+	if _, err := t.micad.Pause(ctx, t.client); err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	s.setPaused(t)
+	return &ptypes.Empty{}, nil
 }
 
 // Resume the container
 func (s *micaTaskService) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Resume\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, "")
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	if t.status != task.Status_PAUSED {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is %s", r.ID, t.status)
+	}
+	if _, err := t.micad.Resume(ctx, t.client); err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	s.setResumed(t)
+	return &ptypes.Empty{}, nil
 }
 
 // Kill a process
+// SIGTERM and SIGKILL stop the client, SIGSTOP and SIGCONT pause and resume it.
 func (s *micaTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Kill\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, r.ExecID)
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	if t.status == task.Status_STOPPED {
+		return nil, errgrpc.ToGRPCf(errdefs.ErrNotFound, "process already finished")
+	}
+
+	sig := unix.Signal(r.Signal)
+	switch sig {
+	case unix.SIGTERM, unix.SIGINT, unix.SIGKILL:
+		if t.status == task.Status_CREATED {
+			// nothing booted yet, there is nothing to stop
+			s.setExited(t, uint32(monitor.KilledStatus(sig)))
+			return &ptypes.Empty{}, nil
+		}
+		if _, err := t.micad.Stop(ctx, t.client); err != nil && !errors.Is(err, communication.ErrConnRefused) {
+			return nil, errgrpc.ToGRPC(err)
+		}
+		s.setExited(t, uint32(monitor.KilledStatus(sig)))
+	case unix.SIGSTOP, unix.SIGTSTP:
+		if _, err := t.micad.Pause(ctx, t.client); err != nil {
+			return nil, errgrpc.ToGRPC(err)
+		}
+		s.setPaused(t)
+	case unix.SIGCONT:
+		if _, err := t.micad.Resume(ctx, t.client); err != nil {
+			return nil, errgrpc.ToGRPC(err)
+		}
+		s.setResumed(t)
+	default:
+		return nil, errgrpc.ToGRPCf(errdefs.ErrNotImplemented, "signal %d for mica clients", r.Signal)
+	}
+	return &ptypes.Empty{}, nil
 }
 
 // Pids returns all pids inside the container
 func (s *micaTaskService) Pids(ctx context.Context, r *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
-	fmt.Printf("hello Pids\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.getTask(r.ID, "")
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+	return &taskAPI.PidsResponse{
+		Processes: []*task.ProcessInfo{{Pid: t.pid}},
+	}, nil
 }
 
 // CloseIO of a process
 func (s *micaTaskService) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello CloseIO\n")
-	return nil, errdefs.ErrNotImplemented
+	return &ptypes.Empty{}, nil
 }
 
 // Checkpoint the container
 func (s *micaTaskService) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Checkpoint\n")
 	return nil, errdefs.ErrNotImplemented
 }
 
 // Connect returns shim information of the underlying service
 func (s *micaTaskService) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (*taskAPI.ConnectResponse, error) {
-	fmt.Printf("hello Connect\n")
-	return nil, errdefs.ErrNotImplemented
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pid uint32
+	if t, ok := s.tasks[r.ID]; ok {
+		pid = t.pid
+	}
+	return &taskAPI.ConnectResponse{
+		ShimPid: uint32(os.Getpid()),
+		TaskPid: pid,
+	}, nil
 }
 
 // Shutdown is called after the underlying resources of the shim are cleaned up and the service can be stopped
 func (s *micaTaskService) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Shutdown\n")
-	os.Exit(0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// return out if the shim is still servicing containers
+	if len(s.tasks) > 0 {
+		return &ptypes.Empty{}, nil
+	}
+	s.shutdown.Shutdown()
 	return &ptypes.Empty{}, nil
 }
 
 // Stats returns container level system stats for a container and its processes
 func (s *micaTaskService) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
-	fmt.Printf("hello Stats\n")
 	return nil, errdefs.ErrNotImplemented
 }
 
 // Update the live container
 func (s *micaTaskService) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
-	fmt.Printf("hello Update\n")
 	return nil, errdefs.ErrNotImplemented
 }
 
 // Wait for a process to exit
 func (s *micaTaskService) Wait(ctx context.Context, r *taskAPI.WaitRequest) (*taskAPI.WaitResponse, error) {
-	fmt.Printf("hello Wait\n")
-	return nil, errdefs.ErrNotImplemented
-} 
\ No newline at end of file
+	s.mu.Lock()
+	t, err := s.getTask(r.ID, r.ExecID)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, errgrpc.ToGRPC(err)
+	}
+
+	select {
+	case <-t.exited:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &taskAPI.WaitResponse{
+		ExitStatus: t.exitStatus,
+		ExitedAt:   protobuf.ToTimestamp(t.exitedAt),
+	}, nil
+}
+
+// watch follows the micad status of a started task until it exited, so that
+// a crashed or externally stopped client is published like any other exit.
+func (s *micaTaskService) watch(t *micaTask) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.exited:
+			return
+		case <-ticker.C:
+		}
+
+		st, err := t.micad.Status(context.Background(), t.client)
+		status, exited, err := monitor.Exited(st, err)
+		s.mu.Lock()
+		switch {
+		case t.status == task.Status_STOPPED:
+		case err != nil:
+			log.L.WithError(err).Warnf("failed to query mica client %s", t.client)
+		case exited:
+			s.setExited(t, uint32(status))
+		case st.State == communication.ClientSuspended && t.status == task.Status_RUNNING:
+			s.setPaused(t)
+		case st.State == communication.ClientRunning && t.status == task.Status_PAUSED:
+			s.setResumed(t)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// setExited, setPaused and setResumed must be called with s.mu held.
+func (s *micaTaskService) setExited(t *micaTask, exitStatus uint32) {
+	t.setExited(exitStatus)
+	s.send(&eventstypes.TaskExit{
+		ContainerID: t.id,
+		ID:          t.id,
+		Pid:         t.pid,
+		ExitStatus:  t.exitStatus,
+		ExitedAt:    protobuf.ToTimestamp(t.exitedAt),
+	})
+}
+
+func (s *micaTaskService) setPaused(t *micaTask) {
+	t.status = task.Status_PAUSED
+	s.send(&eventstypes.TaskPaused{ContainerID: t.id})
+}
+
+func (s *micaTaskService) setResumed(t *micaTask) {
+	t.status = task.Status_RUNNING
+	s.send(&eventstypes.TaskResumed{ContainerID: t.id})
+}
+
+// send queues evt for forward, it never blocks on a slow publisher.
+func (s *micaTaskService) send(evt interface{}) {
+	s.evmu.Lock()
+	defer s.evmu.Unlock()
+	if s.closed {
+		log.L.Warnf("shim is shutting down, dropping event %s", runtime.GetTopic(evt))
+		return
+	}
+	s.pending = append(s.pending, evt)
+	s.notify()
+}
+
+// closeEvents stops the queue, forward publishes what is left and closes
+// the publisher.
+func (s *micaTaskService) closeEvents() {
+	s.evmu.Lock()
+	s.closed = true
+	s.evmu.Unlock()
+	s.notify()
+}
+
+func (s *micaTaskService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *micaTaskService) forward(ctx context.Context, publisher shim.Publisher) {
+	ns, _ := namespaces.Namespace(ctx)
+	ctx = namespaces.WithNamespace(context.Background(), ns)
+	for {
+		<-s.wake
+		s.evmu.Lock()
+		events, closed := s.pending, s.closed
+		s.pending = nil
+		s.evmu.Unlock()
+
+		for _, e := range events {
+			err := publisher.Publish(ctx, runtime.GetTopic(e), e)
+			if err != nil {
+				log.G(ctx).WithError(err).Error("post event")
+			}
+		}
+		if closed {
+			break
+		}
+	}
+	publisher.Close()
+}
@@ -0,0 +1,277 @@
+package micashim
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+
+	"rmica/communication"
+	"rmica/communication/micadtest"
+	"rmica/defs"
+	"rmica/monitor"
+)
+
+// publisher records the topics it publishes. Publish blocks until hold is
+// closed.
+type publisher struct {
+	hold   chan struct{}
+	closed chan struct{}
+
+	mu     sync.Mutex
+	topics []string
+}
+
+func newPublisher(stuck bool) *publisher {
+	p := &publisher{hold: make(chan struct{}), closed: make(chan struct{})}
+	if !stuck {
+		close(p.hold)
+	}
+	return p
+}
+
+func (p *publisher) Publish(ctx context.Context, topic string, event interface{}) error {
+	<-p.hold
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topics = append(p.topics, topic)
+	return nil
+}
+
+func (p *publisher) Close() error {
+	close(p.closed)
+	return nil
+}
+
+func (p *publisher) Topics() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.topics...)
+}
+
+// shutdownService runs the registered callbacks when the test calls them.
+type shutdownService struct {
+	once      sync.Once
+	done      chan struct{}
+	callbacks []func(context.Context) error
+}
+
+func (sd *shutdownService) Shutdown() {
+	sd.once.Do(func() {
+		for _, cb := range sd.callbacks {
+			cb(context.Background())
+		}
+		close(sd.done)
+	})
+}
+
+func (sd *shutdownService) Done() <-chan struct{} { return sd.done }
+func (sd *shutdownService) Err() error            { return nil }
+
+func (sd *shutdownService) RegisterCallback(cb func(context.Context) error) {
+	sd.callbacks = append(sd.callbacks, cb)
+}
+
+var testCtx = namespaces.WithNamespace(context.Background(), "default")
+
+func newService(t *testing.T, pub *publisher) (*micaTaskService, *shutdownService) {
+	t.Helper()
+	sd := &shutdownService{done: make(chan struct{})}
+	s, err := newTaskService(testCtx, pub, sd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sd.Shutdown)
+	return s.(*micaTaskService), sd
+}
+
+// stopService shuts the service down and returns the topics published until then.
+func stopService(t *testing.T, pub *publisher, sd *shutdownService) []string {
+	t.Helper()
+	sd.Shutdown()
+	select {
+	case <-pub.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the publisher was not closed on shutdown")
+	}
+	return pub.Topics()
+}
+
+// newBundle writes a bundle for client zephyr01 on cpu 0 of srv.
+func newBundle(t *testing.T, srv *micadtest.Server) string {
+	t.Helper()
+	bundle := t.TempDir()
+	firmware := filepath.Join(t.TempDir(), "zephyr.elf")
+	if err := os.WriteFile(firmware, []byte("\x7fELF zephyr"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&specs.Spec{
+		Version: specs.Version,
+		Annotations: map[string]string{
+			defs.MicaAnnoClientCPU:      "0",
+			defs.MicaAnnoClientFirmware: firmware,
+			defs.MicaAnnoMicadSocket:    filepath.Join(srv.Dir, defs.MicaSocketName),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bundle, "config.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func newServer(t *testing.T) *micadtest.Server {
+	t.Helper()
+	srv, err := micadtest.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// waitInBackground runs the Wait RPC for zephyr01.
+func waitInBackground(s *micaTaskService) <-chan *taskAPI.WaitResponse {
+	done := make(chan *taskAPI.WaitResponse, 1)
+	go func() {
+		resp, _ := s.Wait(testCtx, &taskAPI.WaitRequest{ID: "zephyr01"})
+		done <- resp
+	}()
+	return done
+}
+
+func waitExit(t *testing.T, done <-chan *taskAPI.WaitResponse) *taskAPI.WaitResponse {
+	t.Helper()
+	select {
+	case resp := <-done:
+		if resp == nil {
+			t.Fatal("wait failed")
+		}
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait missed the exit")
+	}
+	return nil
+}
+
+// A publisher that does not keep up must not block the task service, send
+// is called with s.mu held by every RPC.
+func TestSendWithStuckPublisher(t *testing.T) {
+	pub := newPublisher(true)
+	s, sd := newService(t, pub)
+
+	sent := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := 0; i < 1000; i++ {
+			s.send(&eventstypes.TaskPaused{ContainerID: "zephyr01"})
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked on the publisher")
+	}
+	if _, err := s.Connect(testCtx, &taskAPI.ConnectRequest{ID: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(pub.hold)
+	if n := len(stopService(t, pub, sd)); n != 1000 {
+		t.Errorf("published %d of 1000 events before closing", n)
+	}
+	// too late to publish, but no panic either
+	s.send(&eventstypes.TaskResumed{ContainerID: "zephyr01"})
+}
+
+// create, start, kill, wait and delete a task against micadtest.
+func TestTaskLifecycle(t *testing.T) {
+	srv := newServer(t)
+	pub := newPublisher(false)
+	s, sd := newService(t, pub)
+	bundle := newBundle(t, srv)
+
+	if _, err := s.Create(testCtx, &taskAPI.CreateTaskRequest{ID: "zephyr01", Bundle: bundle}); err != nil {
+		t.Fatal(err)
+	}
+	if client, ok := srv.Client("zephyr01"); !ok || client.State != communication.ClientOffline {
+		t.Fatalf("create left client %+v, registered %v", client, ok)
+	}
+	if name, err := readClientFile(bundle); err != nil || name != "zephyr01" {
+		t.Fatalf("client file says %q: %v", name, err)
+	}
+	if _, err := s.Start(testCtx, &taskAPI.StartRequest{ID: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+	if client, _ := srv.Client("zephyr01"); client.State != communication.ClientRunning {
+		t.Fatalf("start left the client %s", client.State)
+	}
+
+	done := waitInBackground(s)
+	if _, err := s.Kill(testCtx, &taskAPI.KillRequest{ID: "zephyr01", Signal: uint32(unix.SIGTERM)}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := waitExit(t, done); resp.ExitStatus != uint32(monitor.KilledStatus(unix.SIGTERM)) {
+		t.Errorf("wait returned %d, want %d", resp.ExitStatus, monitor.KilledStatus(unix.SIGTERM))
+	}
+	if client, _ := srv.Client("zephyr01"); client.State != communication.ClientOffline {
+		t.Errorf("kill left the client %s", client.State)
+	}
+
+	if _, err := s.Delete(testCtx, &taskAPI.DeleteRequest{ID: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Client("zephyr01"); ok {
+		t.Error("delete kept the client in micad")
+	}
+	if _, err := os.Stat(filepath.Join(bundle, clientFile)); !os.IsNotExist(err) {
+		t.Errorf("client file still there: %v", err)
+	}
+
+	want := []string{"/tasks/create", "/tasks/start", "/tasks/exit", "/tasks/delete"}
+	if got := stopService(t, pub, sd); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+// A client crashing behind the shim's back ends the task.
+func TestWatchCrashed(t *testing.T) {
+	srv := newServer(t)
+	pub := newPublisher(false)
+	s, sd := newService(t, pub)
+	s.interval = 10 * time.Millisecond
+
+	if _, err := s.Create(testCtx, &taskAPI.CreateTaskRequest{ID: "zephyr01", Bundle: newBundle(t, srv)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Start(testCtx, &taskAPI.StartRequest{ID: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+	done := waitInBackground(s)
+	if err := srv.Crash("zephyr01"); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, done)
+	if _, err := s.Delete(testCtx, &taskAPI.DeleteRequest{ID: "zephyr01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/tasks/create", "/tasks/start", "/tasks/exit", "/tasks/delete"}
+	if got := stopService(t, pub, sd); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package micashim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/opencontainers/runtime-spec/specs-go"
+
+	"rmica/communication"
+)
+
+// clientFile in the bundle remembers the micad client of a task, so that
+// manager.Stop can clean up after a shim that died.
+const clientFile = "mica-client"
+
+// micaTask is what the shim knows about the client behind one container.
+// There is no host process for an RTOS client, the shim stands in for it
+// and reports its own pid.
+type micaTask struct {
+	id     string
+	bundle string
+	// rootfs is mounted by Create, "" if containerd sent no rootfs mounts
+	rootfs string
+	client string
+	// micad serving the client, as configured for the bundle
+	micad communication.MicadClient
+	pid   uint32
+
+	status     task.Status
+	stdin      string
+	stdout     string
+	stderr     string
+	terminal   bool
+	exitStatus uint32
+	exitedAt   time.Time
+	// closed once the client stopped
+	exited chan struct{}
+}
+
+// setExited records the end of the client, later calls are ignored.
+func (t *micaTask) setExited(exitStatus uint32) {
+	if t.status == task.Status_STOPPED {
+		return
+	}
+	t.status = task.Status_STOPPED
+	t.exitStatus = exitStatus
+	t.exitedAt = time.Now()
+	close(t.exited)
+}
+
+func readSpec(bundle string) (*specs.Spec, error) {
+	f, err := os.Open(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var spec specs.Spec
+	if err := json.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to decode config.json of %s: %w", bundle, err)
+	}
+	return &spec, nil
+}
+
+// specRootfs is where the client firmware is looked up.
+func specRootfs(bundle string, spec *specs.Spec) string {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return ""
+	}
+	if filepath.IsAbs(spec.Root.Path) {
+		return spec.Root.Path
+	}
+	return filepath.Join(bundle, spec.Root.Path)
+}
+
+// bundleMicad is the micad the client of bundle was created with.
+func bundleMicad(bundle string) communication.MicadClient {
+	src := communication.SocketSource{}
+	if spec, err := readSpec(bundle); err == nil {
+		src.Annotations = spec.Annotations
+	}
+	dir, err := communication.ResolveSocketDir(src)
+	if err != nil {
+		dir = communication.DefaultSocketDir()
+	}
+	return communication.NewSocketClient(dir)
+}
+
+func writeClientFile(bundle, client string) error {
+	return os.WriteFile(filepath.Join(bundle, clientFile), []byte(client), 0o600)
+}
+
+func readClientFile(bundle string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, clientFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}